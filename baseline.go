@@ -0,0 +1,294 @@
+package ltt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Name of the comparison written to Config.ReportDir when a baseline is set
+const ReportComparisonFile = "ltt_comparison.json"
+
+// Allowed differences to a baseline before a task is flagged as regressed
+type Tolerances struct {
+	// Allowed relative increase of the percentiles in percent, e.g. 10 allows 10% slower
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	// Allowed increase of the error rate in percentage points
+	ErrorRate float64 `json:"error_rate"`
+	// Allowed relative decrease of the throughput in percent
+	RPS float64 `json:"rps"`
+	// Tasks with fewer runs in either report are compared, but not flagged
+	MinRuns int64 `json:"min_runs"`
+}
+
+// Returned when a compared report has task stats without a histogram
+var ErrNoHistogram = errors.New("task stats have no histogram")
+
+var DefaultTolerances = Tolerances{
+	P50:       10,
+	P95:       10,
+	P99:       10,
+	ErrorRate: 1,
+	RPS:       10,
+	MinRuns:   10,
+}
+
+// Compared metrics of a task, durations in milliseconds and error rates in percent
+type TaskMetrics struct {
+	Runs      int64   `json:"runs"`
+	P50       float64 `json:"p50"`
+	P95       float64 `json:"p95"`
+	P99       float64 `json:"p99"`
+	ErrorRate float64 `json:"error_rate"`
+	RPS       float64 `json:"rps"`
+}
+
+type TaskComparison struct {
+	Name     string       `json:"name"`
+	Baseline *TaskMetrics `json:"baseline"`
+	Current  *TaskMetrics `json:"current"`
+	// Relative changes in percent for the percentiles and throughput, the
+	// change of the error rate in percentage points
+	P50Delta       float64 `json:"p50_delta"`
+	P95Delta       float64 `json:"p95_delta"`
+	P99Delta       float64 `json:"p99_delta"`
+	ErrorRateDelta float64 `json:"error_rate_delta"`
+	RPSDelta       float64 `json:"rps_delta"`
+	// Descriptions of the metrics that are past the tolerances
+	Regressions []string `json:"regressions"`
+}
+
+func (tc *TaskComparison) Regressed() bool {
+	return len(tc.Regressions) > 0
+}
+
+type Comparison struct {
+	GeneratedAt   time.Time  `json:"generated_at"`
+	BaselineRunID string     `json:"baseline_run_id"`
+	RunID         string     `json:"run_id"`
+	Tolerances    Tolerances `json:"tolerances"`
+	// Tasks sorted by name, including tasks only in one of the reports
+	Tasks      []*TaskComparison `json:"tasks"`
+	Aggregated *TaskComparison   `json:"aggregated"`
+	Regressed  bool              `json:"regressed"`
+}
+
+// Returns the regressed tasks, with the aggregated results last
+func (c *Comparison) Regressions() []*TaskComparison {
+	regressions := []*TaskComparison{}
+	for _, tc := range c.Tasks {
+		if tc.Regressed() {
+			regressions = append(regressions, tc)
+		}
+	}
+
+	if c.Aggregated.Regressed() {
+		regressions = append(regressions, c.Aggregated)
+	}
+
+	return regressions
+}
+
+// Returns the stats of the steady phase of a report, or the combined stats if
+// the report has no steady phase
+func comparableTasks(r *Report) map[string]*TaskStats {
+	if p, ok := r.Stats.Phases[PhaseSteady]; ok && len(p.Tasks) > 0 {
+		return p.Tasks
+	}
+
+	return r.Stats.Tasks
+}
+
+func newTaskMetrics(t *TaskStats, duration float64) (*TaskMetrics, error) {
+	if t.Histogram == nil {
+		return nil, fmt.Errorf("%s: %w", t.Name, ErrNoHistogram)
+	}
+
+	m := &TaskMetrics{
+		Runs: t.TotalRuns,
+		P50:  float64(t.Histogram.ValueAtPercentile(50)) / 1000,
+		P95:  float64(t.Histogram.ValueAtPercentile(95)) / 1000,
+		P99:  float64(t.Histogram.ValueAtPercentile(99)) / 1000,
+	}
+
+	if t.TotalRuns > 0 {
+		m.ErrorRate = float64(t.NumFailed) / float64(t.TotalRuns) * 100
+	}
+	if duration > 0 {
+		m.RPS = float64(t.TotalRuns) / duration
+	}
+
+	return m, nil
+}
+
+// Returns the relative change from a to b in percent
+func relativeDelta(a float64, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+
+	return (b - a) / a * 100
+}
+
+func compareTask(name string, baseline *TaskMetrics, current *TaskMetrics, tol Tolerances) *TaskComparison {
+	tc := &TaskComparison{
+		Name:        name,
+		Baseline:    baseline,
+		Current:     current,
+		Regressions: []string{},
+	}
+
+	if baseline == nil || current == nil {
+		return tc
+	}
+
+	tc.P50Delta = relativeDelta(baseline.P50, current.P50)
+	tc.P95Delta = relativeDelta(baseline.P95, current.P95)
+	tc.P99Delta = relativeDelta(baseline.P99, current.P99)
+	tc.ErrorRateDelta = current.ErrorRate - baseline.ErrorRate
+	tc.RPSDelta = relativeDelta(baseline.RPS, current.RPS)
+
+	if baseline.Runs < tol.MinRuns || current.Runs < tol.MinRuns {
+		return tc
+	}
+
+	check := func(metric string, delta float64, tolerance float64, unit string) {
+		if delta > tolerance {
+			tc.Regressions = append(tc.Regressions,
+				fmt.Sprintf("%s %+.2f%s exceeds tolerance %.2f%s", metric, delta, unit, tolerance, unit))
+		}
+	}
+	check("p50", tc.P50Delta, tol.P50, "%")
+	check("p95", tc.P95Delta, tol.P95, "%")
+	check("p99", tc.P99Delta, tol.P99, "%")
+	check("error rate", tc.ErrorRateDelta, tol.ErrorRate, "pp")
+	// Lower throughput is the regression
+	check("rps", -tc.RPSDelta, tol.RPS, "%")
+
+	return tc
+}
+
+// Compares a report to a baseline report, per task and aggregated. Throughput is
+// relative to the steady phase duration of each report. Reports whose task stats
+// have no histogram can't be compared, ErrNoHistogram is returned for them, and
+// ErrNoStats for reports without stats.
+func CompareReports(baseline *Report, current *Report, tol Tolerances) (*Comparison, error) {
+	if baseline.Stats == nil {
+		return nil, fmt.Errorf("baseline %w", ErrNoStats)
+	} else if current.Stats == nil {
+		return nil, fmt.Errorf("current %w", ErrNoStats)
+	}
+
+	c := &Comparison{
+		GeneratedAt:   time.Now(),
+		BaselineRunID: baseline.Config.RunID,
+		RunID:         current.Config.RunID,
+		Tolerances:    tol,
+		Tasks:         []*TaskComparison{},
+	}
+
+	baseTasks := comparableTasks(baseline)
+	curTasks := comparableTasks(current)
+	baseAgg := NewTaskStat(AggregatedName)
+	curAgg := NewTaskStat(AggregatedName)
+
+	names := []string{}
+	for name := range baseTasks {
+		names = append(names, name)
+	}
+	for name := range curTasks {
+		if _, ok := baseTasks[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var bm, cm *TaskMetrics
+		var err error
+		if t, ok := baseTasks[name]; ok {
+			if bm, err = newTaskMetrics(t, baseline.Duration); err != nil {
+				return nil, fmt.Errorf("baseline task %w", err)
+			}
+			baseAgg.Merge(t)
+		}
+		if t, ok := curTasks[name]; ok {
+			if cm, err = newTaskMetrics(t, current.Duration); err != nil {
+				return nil, fmt.Errorf("current task %w", err)
+			}
+			curAgg.Merge(t)
+		}
+
+		tc := compareTask(name, bm, cm, tol)
+		c.Tasks = append(c.Tasks, tc)
+		c.Regressed = c.Regressed || tc.Regressed()
+	}
+
+	// The aggregated stats always have a histogram
+	bm, _ := newTaskMetrics(baseAgg, baseline.Duration)
+	cm, _ := newTaskMetrics(curAgg, current.Duration)
+	c.Aggregated = compareTask(AggregatedName, bm, cm, tol)
+	c.Regressed = c.Regressed || c.Aggregated.Regressed()
+
+	return c, nil
+}
+
+// Returns the tolerances from the config, DefaultTolerances for those that aren't set
+func (lt *LoadTest) Tolerances() Tolerances {
+	tol := DefaultTolerances
+	if t := lt.Config.BaselineLatencyTolerance; t > 0 {
+		tol.P50 = t
+		tol.P95 = t
+		tol.P99 = t
+	}
+	if t := lt.Config.BaselineErrorRateTolerance; t > 0 {
+		tol.ErrorRate = t
+	}
+	if t := lt.Config.BaselineRPSTolerance; t > 0 {
+		tol.RPS = t
+	}
+
+	return tol
+}
+
+// Compares the current stats to the baseline report with the configured tolerances
+func (lt *LoadTest) CompareWithBaseline(baseline *Report) (*Comparison, error) {
+	r, err := lt.NewReport()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareReports(baseline, r, lt.Tolerances())
+}
+
+// Loads the baseline report from Config.BaselineFile
+func (lt *LoadTest) loadBaseline() {
+	if lt.Config.BaselineFile == "" {
+		return
+	}
+
+	r, err := LoadReport(lt.Config.BaselineFile)
+	if err != nil {
+		lt.Log.Fatalf("failed to load baseline: %s\n", err.Error())
+	}
+
+	lt.Baseline = r
+	lt.Log.Printf("Loaded baseline report of run %s\n", r.Config.RunID)
+}
+
+// Logs the regressions compared to the baseline
+func (lt *LoadTest) logComparison(c *Comparison) {
+	if !c.Regressed {
+		lt.Log.Printf("No regressions compared to baseline run %s\n", c.BaselineRunID)
+		return
+	}
+
+	for _, tc := range c.Regressions() {
+		for _, r := range tc.Regressions {
+			lt.Log.Printf("Regression compared to baseline run %s: %s: %s\n", c.BaselineRunID, tc.Name, r)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package ltt
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a report with 100 runs of the task in the steady phase, every 1/failEvery run failed
+func newTestReport(runID string, name string, d time.Duration, failEvery int) *Report {
+	stats := NewStatistics()
+	steady := NewPhaseStats(PhaseSteady)
+	stats.Phases[PhaseSteady] = steady
+	ts := NewTaskStat(name)
+	steady.Tasks[name] = ts
+	for i := 0; i < 100; i++ {
+		tr := &TaskRun{Duration: d}
+		if failEvery > 0 && i%failEvery == 0 {
+			tr.Error = errBench
+		}
+		ts.Record(tr, time.Now())
+	}
+	stats.Tasks[name] = ts
+
+	return &Report{
+		Config:   Config{RunID: runID},
+		Duration: 10,
+		Stats:    stats,
+	}
+}
+
+func TestCompareReportsNoRegression(t *testing.T) {
+	base := newTestReport("base", "task", 100*time.Millisecond, 0)
+	cur := newTestReport("cur", "task", 105*time.Millisecond, 0)
+
+	c, err := CompareReports(base, cur, DefaultTolerances)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Regressed || len(c.Regressions()) > 0 {
+		t.Fatalf("unexpected regressions: %v", c.Tasks[0].Regressions)
+	}
+	if c.BaselineRunID != "base" || c.RunID != "cur" {
+		t.Fatalf("run IDs = %s, %s", c.BaselineRunID, c.RunID)
+	}
+	if len(c.Tasks) != 1 || c.Tasks[0].Current.Runs != 100 || c.Tasks[0].Current.RPS != 10 {
+		t.Fatalf("unexpected task comparisons: %+v", c.Tasks)
+	}
+}
+
+func TestCompareReportsRegressions(t *testing.T) {
+	base := newTestReport("base", "task", 100*time.Millisecond, 0)
+	// 50% slower and 10% failed
+	cur := newTestReport("cur", "task", 150*time.Millisecond, 10)
+
+	c, err := CompareReports(base, cur, DefaultTolerances)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Regressed {
+		t.Fatal("not regressed")
+	}
+	tc := c.Tasks[0]
+	if tc.P50Delta < 49 || tc.P50Delta > 51 {
+		t.Fatalf("P50Delta = %f, want ~50", tc.P50Delta)
+	}
+	if tc.ErrorRateDelta != 10 {
+		t.Fatalf("ErrorRateDelta = %f, want 10", tc.ErrorRateDelta)
+	}
+	regressions := strings.Join(tc.Regressions, "\n")
+	for _, metric := range []string{"p50", "p95", "p99", "error rate"} {
+		if !strings.Contains(regressions, metric+" ") {
+			t.Fatalf("%s isn't a regression: %s", metric, regressions)
+		}
+	}
+	if rs := c.Regressions(); len(rs) != 2 || rs[1] != c.Aggregated {
+		t.Fatal("the task and the aggregated results should be regressed")
+	}
+
+	// Within larger tolerances
+	tol := DefaultTolerances
+	tol.P50, tol.P95, tol.P99, tol.ErrorRate = 60, 60, 60, 20
+	if c, _ := CompareReports(base, cur, tol); c.Regressed {
+		t.Fatalf("regressed within tolerances: %v", c.Tasks[0].Regressions)
+	}
+
+	// Too few runs to be flagged
+	tol = DefaultTolerances
+	tol.MinRuns = 1000
+	if c, _ := CompareReports(base, cur, tol); c.Regressed {
+		t.Fatal("regressed with fewer runs than MinRuns")
+	}
+}
+
+func TestCompareReportsTasksInOneReport(t *testing.T) {
+	base := newTestReport("base", "old", 100*time.Millisecond, 0)
+	cur := newTestReport("cur", "new", 100*time.Millisecond, 0)
+
+	c, err := CompareReports(base, cur, DefaultTolerances)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Tasks) != 2 || c.Tasks[0].Name != "new" || c.Tasks[0].Baseline != nil || c.Tasks[1].Current != nil {
+		t.Fatalf("unexpected task comparisons: %+v", c.Tasks)
+	}
+	if c.Tasks[0].Regressed() || c.Tasks[1].Regressed() {
+		t.Fatal("tasks in one report are regressed")
+	}
+}
+
+func TestCompareReportsNoHistogram(t *testing.T) {
+	base := newTestReport("base", "task", 100*time.Millisecond, 0)
+	// A report of a version without histograms
+	data := []byte(`{"duration": 10, "stats": {"tasks": {"task": {"name": "task", "total_runs": 100}}}}`)
+	old := &Report{}
+	if err := json.Unmarshal(data, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CompareReports(old, base, DefaultTolerances); !errors.Is(err, ErrNoHistogram) {
+		t.Fatalf("err = %v, want ErrNoHistogram", err)
+	}
+	if _, err := CompareReports(base, old, DefaultTolerances); !errors.Is(err, ErrNoHistogram) {
+		t.Fatalf("err = %v, want ErrNoHistogram", err)
+	}
+}
+
+func TestLoadTestTolerances(t *testing.T) {
+	lt := NewLoadTest(Config{})
+	if tol := lt.Tolerances(); tol != DefaultTolerances {
+		t.Fatalf("Tolerances() = %+v, want the defaults", tol)
+	}
+
+	lt = NewLoadTest(Config{BaselineLatencyTolerance: 25, BaselineRPSTolerance: -1})
+	tol := lt.Tolerances()
+	if tol.P50 != 25 || tol.P95 != 25 || tol.P99 != 25 {
+		t.Fatalf("latency tolerances = %f, %f, %f, want 25", tol.P50, tol.P95, tol.P99)
+	}
+	if tol.ErrorRate != DefaultTolerances.ErrorRate || tol.RPS != DefaultTolerances.RPS {
+		t.Fatalf("tolerances that aren't above 0 = %f, %f, want the defaults", tol.ErrorRate, tol.RPS)
+	}
+}
+
+func TestReportsWithoutStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := ioutil.WriteFile(path, []byte(`{"duration": 10}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReport(path); !errors.Is(err, ErrNoStats) {
+		t.Fatalf("LoadReport err = %v, want ErrNoStats", err)
+	}
+
+	r := newTestReport("cur", "task", 100*time.Millisecond, 0)
+	if _, err := CompareReports(&Report{}, r, DefaultTolerances); !errors.Is(err, ErrNoStats) {
+		t.Fatalf("CompareReports err = %v, want ErrNoStats", err)
+	}
+	if _, err := CompareReports(r, &Report{}, DefaultTolerances); !errors.Is(err, ErrNoStats) {
+		t.Fatalf("CompareReports err = %v, want ErrNoStats", err)
+	}
+}
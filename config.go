@@ -7,6 +7,8 @@ import (
 )
 
 type Config struct {
+	// Identifies the run in exported results, defaults to the start time
+	RunID string `json:"run_id"`
 	// Host to bind the REST API to. default all (empty string).
 	APIHost string `json:"api_host"`
 	// Port to bind the REST API to, default 4141
@@ -18,6 +20,8 @@ type Config struct {
 	RequestTimeout int `json:"request_timeout"`
 	// Custom user type to override the DefaultUser
 	UserType User `json:"-"`
+	// Labels of the user class, added as tags to all task runs, "label,label2"
+	UserLabels string `json:"user_labels"`
 	// Min sleep time between tasks in seconds
 	MinSleepTime int `json:"min_sleep_time"`
 	// Max sleep time between tasks in seconds
@@ -26,6 +30,75 @@ type Config struct {
 	Verbose bool `json:"verbose"`
 	// If we should start spawning users on startup
 	SpawnOnStartup bool `json:"spawn_on_startup"`
+	// If true, a user whose task panics is restarted from the entry task with
+	// its context and storage kept, otherwise it continues with its next task
+	RestartOnPanic bool `json:"restart_on_panic"`
+	// File to save user sessions to when the users stop or the load test shuts down, and to
+	// restore them from on the next run. Sessions are not persisted if empty.
+	SessionFile string `json:"session_file"`
+	// Max age of a saved session in seconds for it to be restored, 0 means no limit
+	SessionMaxAge int `json:"session_max_age"`
+	// Sliding window in seconds for the current throughput, at most MaxRPSWindow
+	RPSWindow int `json:"rps_window"`
+	// Seconds between each stats history snapshot
+	HistoryInterval int `json:"history_interval"`
+	// Number of stats history snapshots to keep
+	HistorySize int `json:"history_size"`
+	// Directory to write the CSV and JSON reports to, no reports are written if empty
+	ReportDir string `json:"report_dir"`
+	// Seconds between writing the reports, they are also written on shutdown
+	ReportInterval int `json:"report_interval"`
+	// Address to push metrics to with StatsD over UDP or Graphite over TCP, disabled if empty
+	StatsDAddress string `json:"statsd_address"`
+	// "statsd" or "graphite"
+	StatsDProtocol string `json:"statsd_protocol"`
+	// Prefix of all pushed metric paths
+	StatsDPrefix string `json:"statsd_prefix"`
+	// Tags added to all pushed metrics, "k=v,k2=v2"
+	StatsDTags string `json:"statsd_tags"`
+	// Seconds between each push
+	StatsDInterval int `json:"statsd_interval"`
+	// Where to write InfluxDB line protocol to, a file://, udp:// or http(s):// URL, disabled if empty
+	InfluxURL string `json:"influx_url"`
+	// Token for the InfluxDB HTTP API
+	InfluxToken string `json:"-"`
+	// If true, aggregated task results are written each interval instead of every task run
+	InfluxAggregate bool `json:"influx_aggregate"`
+	// Seconds between each write
+	InfluxInterval int `json:"influx_interval"`
+	// If true, each task run gets a trace ID that HTTPClient requests carry in a
+	// W3C traceparent header, and that is recorded with slow and failed samples
+	TraceContext bool `json:"trace_context"`
+	// Report of a previous run to compare this run to, see CompareReports
+	BaselineFile string `json:"baseline_file"`
+	// Allowed relative increase of the p50, p95 and p99 durations in percent,
+	// 0 means the DefaultTolerances value, as for the other tolerances
+	BaselineLatencyTolerance float64 `json:"baseline_latency_tolerance"`
+	// Allowed increase of the error rate in percentage points
+	BaselineErrorRateTolerance float64 `json:"baseline_error_rate_tolerance"`
+	// Allowed relative decrease of the throughput in percent
+	BaselineRPSTolerance float64 `json:"baseline_rps_tolerance"`
+	// Default Apdex threshold in milliseconds of tasks without one, 0 disables Apdex for them
+	ApdexThreshold int `json:"apdex_threshold"`
+	// File to stream a sample of all task runs to, disabled if empty
+	SampleLogFile string `json:"sample_log_file"`
+	// "jsonl" or "binary"
+	SampleLogFormat string `json:"sample_log_format"`
+	// Ratio of the task runs that are logged, between 0 and 1
+	SampleLogRatio float64 `json:"sample_log_ratio"`
+	// Size in megabytes at which the sample log is rotated, 0 disables rotation
+	SampleLogMaxSize int `json:"sample_log_max_size"`
+	// Default expected interval in milliseconds between the starts of a user's task
+	// runs, to correct the latencies for coordinated omission, 0 disables the correction.
+	// For an arrival rate of r runs per second per user the interval is 1000/r.
+	// The missing runs are back-filled a histogram bucket at a time, so that a small
+	// interval and a slow run cost at most a few thousand updates, not one per missing run.
+	ExpectedInterval int `json:"expected_interval"`
+	// Number of shards task runs are recorded in, defaults to DefaultStatsShards. Each
+	// shard keeps two partial copies of the stats, which take about 150 KB per task,
+	// 60 KB per task for each further phase or tag, and 30 KB per request name. More
+	// shards only pay off when recording runs contends on a generator with many CPUs.
+	StatsShards int `json:"stats_shards"`
 	// Logging params
 	LogOutput io.Writer `json:"-"`
 	LogPrefix string    `json:"log_prefix"`
@@ -45,6 +118,37 @@ func NewConfigFromFlags() Config {
 	flag.IntVar(&conf.APIPort, "api-port", 4141, "REST API port to bind to.")
 	flag.BoolVar(&conf.Verbose, "verbose", false, "Verbose logging")
 	flag.BoolVar(&conf.SpawnOnStartup, "spawn-on-startup", false, "If true, spawning will begin on startup")
+	flag.BoolVar(&conf.RestartOnPanic, "restart-on-panic", false, "If true, a user is restarted from the entry task when a task panics")
+	flag.StringVar(&conf.SessionFile, "session-file", "", "File to persist user sessions to between runs")
+	flag.IntVar(&conf.SessionMaxAge, "session-max-age", 0, "Max age of a persisted session in seconds, 0 means no limit")
+	flag.IntVar(&conf.RPSWindow, "rps-window", RPSTimeWindow, "Sliding window in seconds for the current throughput")
+	flag.IntVar(&conf.HistoryInterval, "history-interval", 1, "Seconds between each stats history snapshot")
+	flag.IntVar(&conf.HistorySize, "history-size", DefaultHistorySize, "Number of stats history snapshots to keep")
+	flag.StringVar(&conf.ReportDir, "report-dir", "", "Directory to write CSV and JSON reports to")
+	flag.IntVar(&conf.ReportInterval, "report-interval", 60, "Seconds between writing the reports")
+	flag.StringVar(&conf.StatsDAddress, "statsd-address", "", "Address to push StatsD or Graphite metrics to")
+	flag.StringVar(&conf.StatsDProtocol, "statsd-protocol", StatsDProtocolStatsD, "Metrics push protocol, statsd (UDP) or graphite (TCP)")
+	flag.StringVar(&conf.StatsDPrefix, "statsd-prefix", "ltt", "Prefix of the pushed metric paths")
+	flag.StringVar(&conf.StatsDTags, "statsd-tags", "", "Tags added to the pushed metrics, k=v,k2=v2")
+	flag.IntVar(&conf.StatsDInterval, "statsd-interval", 10, "Seconds between each metrics push")
+	flag.StringVar(&conf.RunID, "run-id", "", "Identifies the run in exported results, defaults to the start time")
+	flag.StringVar(&conf.InfluxURL, "influx-url", "", "file://, udp:// or http(s):// URL to write InfluxDB line protocol to")
+	flag.StringVar(&conf.InfluxToken, "influx-token", "", "Token for the InfluxDB HTTP API")
+	flag.BoolVar(&conf.InfluxAggregate, "influx-aggregate", false, "Write aggregated task results instead of every task run")
+	flag.IntVar(&conf.InfluxInterval, "influx-interval", 10, "Seconds between each InfluxDB write")
+	flag.BoolVar(&conf.TraceContext, "trace-context", false, "Propagate W3C trace context from HTTP requests")
+	flag.StringVar(&conf.BaselineFile, "baseline", "", "JSON report of a previous run to compare this run to")
+	flag.Float64Var(&conf.BaselineLatencyTolerance, "baseline-latency-tolerance", DefaultTolerances.P95, "Allowed percentile increase in percent compared to the baseline")
+	flag.Float64Var(&conf.BaselineErrorRateTolerance, "baseline-error-rate-tolerance", DefaultTolerances.ErrorRate, "Allowed error rate increase in percentage points compared to the baseline")
+	flag.Float64Var(&conf.BaselineRPSTolerance, "baseline-rps-tolerance", DefaultTolerances.RPS, "Allowed throughput decrease in percent compared to the baseline")
+	flag.StringVar(&conf.UserLabels, "user-labels", "", "Labels of the user class, added as tags to all task runs, label,label2")
+	flag.IntVar(&conf.ApdexThreshold, "apdex-threshold", 0, "Default Apdex threshold in milliseconds of tasks without one, 0 disables it")
+	flag.IntVar(&conf.StatsShards, "stats-shards", DefaultStatsShards, "Number of shards task runs are recorded in")
+	flag.StringVar(&conf.SampleLogFile, "sample-log", "", "File to stream a sample of all task runs to")
+	flag.StringVar(&conf.SampleLogFormat, "sample-log-format", SampleLogFormatJSONL, "Sample log format, jsonl or binary")
+	flag.Float64Var(&conf.SampleLogRatio, "sample-log-ratio", 1, "Ratio of the task runs that are logged, between 0 and 1")
+	flag.IntVar(&conf.SampleLogMaxSize, "sample-log-max-size", 0, "Size in megabytes at which the sample log is rotated, 0 disables rotation")
+	flag.IntVar(&conf.ExpectedInterval, "expected-interval", 0, "Expected milliseconds between a user's task runs to correct latencies for coordinated omission, 0 disables it")
 	flag.Parse()
 
 	if conf.LogOutput == nil {
@@ -0,0 +1,116 @@
+package ltt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+type ErrorClass string
+
+const (
+	ErrorClassHTTP4xx           ErrorClass = "http_4xx"
+	ErrorClassHTTP5xx           ErrorClass = "http_5xx"
+	ErrorClassTimeout           ErrorClass = "timeout"
+	ErrorClassConnectionRefused ErrorClass = "connection_refused"
+	ErrorClassConnection        ErrorClass = "connection"
+	ErrorClassDNS               ErrorClass = "dns"
+	ErrorClassTLS               ErrorClass = "tls"
+	ErrorClassPanic             ErrorClass = "panic"
+	ErrorClassTask              ErrorClass = "task_error"
+)
+
+const (
+	// Max number of distinct error keys per task, including the key the rest are counted under
+	MaxErrorKeys = 100
+	// Number of samples kept per error class
+	MaxErrorSamples = 3
+	// Max size in bytes of a sample
+	MaxErrorSampleSize = 512
+)
+
+// Key used for errors once MaxErrorKeys has been reached
+const otherErrorMessage = "(other)"
+
+type ErrorClassStats struct {
+	Count int64 `json:"count"`
+	// Sample response bodies for HTTP errors, error messages otherwise
+	Samples []string `json:"samples"`
+}
+
+func (ecs *ErrorClassStats) addSample(sample string) {
+	if len(ecs.Samples) < MaxErrorSamples {
+		ecs.Samples = append(ecs.Samples, truncate(sample, MaxErrorSampleSize))
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	return s[:max] + "..."
+}
+
+// Classifies an error returned from a task and returns its class and a
+// normalized message where numbers and addresses have been replaced
+func ClassifyError(err error) (ErrorClass, string) {
+	var panicErr *TaskPanicError
+	var httpErr *HTTPError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case errors.As(err, &panicErr):
+		return ErrorClassPanic, normalizeMessage(panicErr.Error())
+	case errors.As(err, &httpErr):
+		class := ErrorClassHTTP4xx
+		if httpErr.StatusCode >= 500 {
+			class = ErrorClassHTTP5xx
+		}
+		return class, fmt.Sprintf("%s %s: status %d", httpErr.Method, normalizeMessage(httpErr.Path), httpErr.StatusCode)
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS, normalizeMessage(dnsErr.Err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout, "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused, "connection refused"
+	case isTLSError(err):
+		return ErrorClassTLS, normalizeMessage(rootError(err).Error())
+	case errors.As(err, &opErr):
+		return ErrorClassConnection, normalizeMessage(rootError(err).Error())
+	}
+
+	return ErrorClassTask, normalizeMessage(err.Error())
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &certErr) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// Returns the innermost wrapped error, which has the least request specific message
+func rootError(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
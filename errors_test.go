@@ -0,0 +1,62 @@
+package ltt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Returns a distinct error message without digits, which normalization would replace
+func distinctError(i int) error {
+	return fmt.Errorf("failed %c%c", 'a'+i/26%26, 'a'+i%26)
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		class ErrorClass
+		msg   string
+	}{
+		{&HTTPError{StatusCode: 404, Method: "GET", Path: "/users/123"}, ErrorClassHTTP4xx, "GET /users/<n>: status 404"},
+		{&HTTPError{StatusCode: 503, Method: "POST", Path: "/orders"}, ErrorClassHTTP5xx, "POST /orders: status 503"},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), ErrorClassTimeout, "timeout"},
+		{&TaskPanicError{Value: "index 5 out of range"}, ErrorClassPanic, "panic: index <n> out of range"},
+		{errors.New("order 42 not found at 0xc000123"), ErrorClassTask, "order <n> not found at <addr>"},
+	} {
+		class, msg := ClassifyError(c.err)
+		if class != c.class || msg != c.msg {
+			t.Fatalf("ClassifyError(%v) = %s, %q, want %s, %q", c.err, class, msg, c.class, c.msg)
+		}
+	}
+}
+
+func TestErrorKeysAreBounded(t *testing.T) {
+	ts := NewTaskStat("task")
+	for i := 0; i < 2*MaxErrorKeys; i++ {
+		ts.AddError(distinctError(i))
+	}
+
+	if n := len(ts.Errors); n != MaxErrorKeys {
+		t.Fatalf("%d error keys, want MaxErrorKeys", n)
+	}
+	if n := ts.Errors[otherErrorMessage]; n != MaxErrorKeys+1 {
+		t.Fatalf("%d errors counted as %s, want %d", n, otherErrorMessage, MaxErrorKeys+1)
+	}
+	// Known keys are still counted under their key
+	ts.AddError(distinctError(0))
+	if n := ts.Errors["task_error: failed aa"]; n != 2 {
+		t.Fatalf("known error counted %d times, want 2", n)
+	}
+
+	// Merged keys are bounded as well
+	other := NewTaskStat("task")
+	for i := 2 * MaxErrorKeys; i < 3*MaxErrorKeys; i++ {
+		other.AddError(distinctError(i))
+	}
+	other.TotalRuns = MaxErrorKeys
+	ts.Merge(other)
+	if n := len(ts.Errors); n != MaxErrorKeys {
+		t.Fatalf("%d error keys after merge, want MaxErrorKeys", n)
+	}
+}
@@ -0,0 +1,268 @@
+package ltt
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Storage key prefix of the row a user has been assigned from a feeder
+const FeederStorageKeyPrefix = "feeder:"
+
+type FeederPolicyType int
+
+const (
+	// Each user gets its own row, which it keeps for its lifetime
+	FeederPolicyUnique FeederPolicyType = iota
+	// Rows are handed out in order, starting over when all have been used
+	FeederPolicyRoundRobin
+	// Rows are picked at random
+	FeederPolicyRandom
+	// Rows are handed out in order and only once
+	FeederPolicyConsumeOnce
+)
+
+// What to do when a FeederPolicyUnique or FeederPolicyConsumeOnce feeder runs out of rows
+type FeederExhaustedType int
+
+const (
+	// Return ErrFeederExhausted to the task
+	FeederExhaustedError FeederExhaustedType = iota
+	// Start over from the first row, only for FeederPolicyConsumeOnce as a unique
+	// row can't be handed out again, see ErrFeederRecycleUnique
+	FeederExhaustedRecycle
+	// Stop the user and return ErrFeederExhausted to the task
+	FeederExhaustedStopUser
+)
+
+var (
+	ErrFeederExhausted    = errors.New("feeder exhausted")
+	ErrFeederNotFound     = errors.New("feeder not found")
+	ErrInvalidFeederValue = errors.New("invalid feeder value")
+	// Returned by FeederPolicyUnique feeders with FeederExhaustedRecycle set
+	ErrFeederRecycleUnique = errors.New("feeder rows can't be recycled with the unique policy")
+)
+
+// A single row of test data, CSV rows only have string values
+type FeederRow map[string]interface{}
+
+func (r FeederRow) GetString(key string) (string, error) {
+	if v, ok := r[key].(string); ok {
+		return v, nil
+	}
+
+	return "", ErrInvalidFeederValue
+}
+
+// Returns the value as an int, CSV string values are parsed
+func (r FeederRow) GetInt(key string) (int, error) {
+	switch v := r[key].(type) {
+	case float64:
+		return int(v), nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, ErrInvalidFeederValue
+		}
+		return i, nil
+	}
+
+	return 0, ErrInvalidFeederValue
+}
+
+// Hands out rows of test data to users according to its policy, safe for concurrent use
+type Feeder struct {
+	sync.Mutex
+	Name        string
+	Policy      FeederPolicyType
+	OnExhausted FeederExhaustedType
+	rows        []FeederRow
+	next        int
+	// user ID -> row for FeederPolicyUnique
+	assigned map[int64]FeederRow
+}
+
+func (f *Feeder) Len() int {
+	return len(f.rows)
+}
+
+// Returns the next row for the user according to the feeder policy
+func (f *Feeder) Next(u User) (FeederRow, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if len(f.rows) == 0 {
+		return nil, ErrFeederExhausted
+	}
+
+	switch f.Policy {
+	case FeederPolicyUnique:
+		if f.OnExhausted == FeederExhaustedRecycle {
+			return nil, ErrFeederRecycleUnique
+		}
+		if row, ok := f.assigned[u.ID()]; ok {
+			return row, nil
+		}
+
+		row, err := f.take(u)
+		if err != nil {
+			return nil, err
+		}
+		f.assigned[u.ID()] = row
+		return row, nil
+	case FeederPolicyRoundRobin:
+		row := f.rows[f.next%len(f.rows)]
+		f.next++
+		return row, nil
+	case FeederPolicyRandom:
+		return f.rows[rand.Intn(len(f.rows))], nil
+	case FeederPolicyConsumeOnce:
+		return f.take(u)
+	}
+
+	return nil, fmt.Errorf("unknown feeder policy: %d", f.Policy)
+}
+
+// Takes the next unused row, must be called with the lock held
+func (f *Feeder) take(u User) (FeederRow, error) {
+	if f.next >= len(f.rows) {
+		switch f.OnExhausted {
+		case FeederExhaustedRecycle:
+			f.next = 0
+		case FeederExhaustedStopUser:
+			u.SetStatus(UserStatusStopping)
+			return nil, ErrFeederExhausted
+		default:
+			return nil, ErrFeederExhausted
+		}
+	}
+
+	row := f.rows[f.next]
+	f.next++
+	return row, nil
+}
+
+func NewFeeder(name string, rows []FeederRow, policy FeederPolicyType) *Feeder {
+	return &Feeder{
+		Name:     name,
+		Policy:   policy,
+		rows:     rows,
+		assigned: make(map[int64]FeederRow),
+	}
+}
+
+// Loads a CSV file where the first line is the header
+func NewFeederFromCSV(name string, path string, policy FeederPolicyType) (*Feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	rows := []FeederRow{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		row := make(FeederRow, len(header))
+		for i, key := range header {
+			if i < len(record) {
+				row[key] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return NewFeeder(name, rows, policy), nil
+}
+
+// Loads a file with a JSON object on each line
+func NewFeederFromJSONL(name string, path string, policy FeederPolicyType) (*Feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows := []FeederRow{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		row := FeederRow{}
+		if err := json.Unmarshal([]byte(data), &row); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d: %w", line, err)
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewFeeder(name, rows, policy), nil
+}
+
+// Loads a CSV or JSONL file depending on the file extension
+func NewFeederFromFile(name string, path string, policy FeederPolicyType) (*Feeder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return NewFeederFromCSV(name, path, policy)
+	case ".jsonl", ".ndjson":
+		return NewFeederFromJSONL(name, path, policy)
+	}
+
+	return nil, fmt.Errorf("unsupported feeder file type: %s", path)
+}
+
+// Returns the row the user has been assigned from the named feeder, a row
+// is drawn from the feeder and saved in the user's Storage on first use
+func FeederRowFromContext(ctx context.Context, name string) (FeederRow, error) {
+	if row, ok := StorageFromContext(ctx).Get(FeederStorageKeyPrefix + name).(FeederRow); ok {
+		return row, nil
+	}
+
+	return NextFeederRow(ctx, name)
+}
+
+// Draws a new row for the user from the named feeder and saves it in the user's Storage
+func NextFeederRow(ctx context.Context, name string) (FeederRow, error) {
+	f, ok := FromContext(ctx).Feeders[name]
+	if !ok {
+		return nil, ErrFeederNotFound
+	}
+
+	row, err := f.Next(UserFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	StorageFromContext(ctx).Set(FeederStorageKeyPrefix+name, row)
+	return row, nil
+}
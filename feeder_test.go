@@ -0,0 +1,62 @@
+package ltt
+
+import (
+	"testing"
+)
+
+func newTestFeederRows(n int) []FeederRow {
+	rows := make([]FeederRow, n)
+	for i := range rows {
+		rows[i] = FeederRow{"i": float64(i)}
+	}
+
+	return rows
+}
+
+func newTestUser(id int64) User {
+	u := NewDefaultUser(nil)
+	u.SetID(id)
+	return u
+}
+
+func TestFeederUniqueRejectsRecycle(t *testing.T) {
+	f := NewFeeder("users", newTestFeederRows(2), FeederPolicyUnique)
+	f.OnExhausted = FeederExhaustedRecycle
+
+	if _, err := f.Next(newTestUser(0)); err != ErrFeederRecycleUnique {
+		t.Fatalf("err = %v, want ErrFeederRecycleUnique", err)
+	}
+}
+
+func TestFeederUniqueKeepsRows(t *testing.T) {
+	f := NewFeeder("users", newTestFeederRows(2), FeederPolicyUnique)
+	u0, u1, u2 := newTestUser(0), newTestUser(1), newTestUser(2)
+
+	r0, _ := f.Next(u0)
+	r1, _ := f.Next(u1)
+	if _, err := f.Next(u2); err != ErrFeederExhausted {
+		t.Fatalf("err = %v, want ErrFeederExhausted", err)
+	}
+	if r, _ := f.Next(u0); r["i"] != r0["i"] {
+		t.Fatalf("user 0 got row %v, want its row %v", r["i"], r0["i"])
+	}
+	if r0["i"] == r1["i"] {
+		t.Fatal("the users share a row")
+	}
+}
+
+func TestFeederConsumeOnceRecycle(t *testing.T) {
+	f := NewFeeder("orders", newTestFeederRows(2), FeederPolicyConsumeOnce)
+	f.OnExhausted = FeederExhaustedRecycle
+	u := newTestUser(0)
+
+	for i := 0; i < 5; i++ {
+		r, err := f.Next(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r["i"] != float64(i%2) {
+			t.Fatalf("row %d is %v, want %d", i, r["i"], i%2)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package ltt
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// The histogram buckets are log2 sized and each split into linear sub buckets,
+// giving a relative error of at most 1/64 (~1.6%) with a fixed memory footprint.
+// Values are recorded in microseconds.
+const (
+	histSubBucketBits  = 7
+	histSubBucketCount = 1 << histSubBucketBits
+	histSubBucketHalf  = histSubBucketCount / 2
+	// Values above ~19 hours are recorded as the max value
+	histMaxValueBits = 36
+	histMaxValue     = 1<<histMaxValueBits - 1
+	histNumCounts    = histSubBucketCount + (histMaxValueBits-histSubBucketBits)*histSubBucketHalf
+)
+
+var ErrHistogramLayout = errors.New("histogram layout mismatch")
+
+// Log-bucketed latency histogram in the style of HdrHistogram, it is mergeable
+// and serializable. Not safe for concurrent use.
+type Histogram struct {
+	counts     [histNumCounts]int64
+	totalCount int64
+	min        int64
+	max        int64
+	sum        float64
+	sumSquares float64
+}
+
+func histIndex(v int64) int {
+	if v < histSubBucketCount {
+		return int(v)
+	}
+
+	exp := bits.Len64(uint64(v)) - histSubBucketBits
+	sub := int(v >> uint(exp))
+	return histSubBucketCount + (exp-1)*histSubBucketHalf + (sub - histSubBucketHalf)
+}
+
+// Returns the highest value that is recorded in the bucket at the index
+func histValue(idx int) int64 {
+	if idx < histSubBucketCount {
+		return int64(idx)
+	}
+
+	exp := (idx-histSubBucketCount)/histSubBucketHalf + 1
+	sub := int64((idx-histSubBucketCount)%histSubBucketHalf + histSubBucketHalf)
+	return (sub+1)<<uint(exp) - 1
+}
+
+// Returns the lowest value that is recorded in the bucket at the index
+func histLowestValue(idx int) int64 {
+	if idx < histSubBucketCount {
+		return int64(idx)
+	}
+
+	exp := (idx-histSubBucketCount)/histSubBucketHalf + 1
+	sub := int64((idx-histSubBucketCount)%histSubBucketHalf + histSubBucketHalf)
+	return sub << uint(exp)
+}
+
+// Records a value in microseconds n times
+func (h *Histogram) RecordValues(us int64, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	if us < 0 {
+		us = 0
+	} else if us > histMaxValue {
+		us = histMaxValue
+	}
+
+	h.counts[histIndex(us)] += n
+	if h.totalCount == 0 || us < h.min {
+		h.min = us
+	}
+	if us > h.max {
+		h.max = us
+	}
+	h.totalCount += n
+	h.sum += float64(us) * float64(n)
+	h.sumSquares += float64(us) * float64(us) * float64(n)
+}
+
+// Records a value in microseconds
+func (h *Histogram) RecordValue(us int64) {
+	h.RecordValues(us, 1)
+}
+
+func (h *Histogram) RecordDuration(d time.Duration) {
+	h.RecordValue(d.Microseconds())
+}
+
+// Records a value and back-fills the values that coordinated omission hid, like
+// HdrHistogram's recordValueWithExpectedInterval. A value above the expected
+// interval between samples means the samples that would have been taken while
+// it was pending are missing, they're recorded as decreasing by the interval.
+// The missing values are recorded a bucket at a time, so a value many times the
+// interval costs at most one update per bucket instead of one per missing value.
+func (h *Histogram) RecordCorrectedValue(us int64, expectedInterval int64) {
+	if us > histMaxValue {
+		us = histMaxValue
+	}
+
+	h.RecordValue(us)
+	if expectedInterval <= 0 {
+		return
+	}
+
+	h.recordSequence(us-expectedInterval, expectedInterval, expectedInterval)
+}
+
+// Records the values from, from-step, from-2*step... down to the lowest one that is at least to
+func (h *Histogram) recordSequence(from int64, to int64, step int64) {
+	for v := from; v >= to; {
+		idx := histIndex(v)
+		lo := histLowestValue(idx)
+		if lo < to {
+			lo = to
+		}
+
+		// The values v-k*step for k = 0..n-1 are in the bucket
+		n := (v-lo)/step + 1
+		last := v - (n-1)*step
+		h.counts[idx] += n
+		if h.totalCount == 0 || last < h.min {
+			h.min = last
+		}
+		if v > h.max {
+			h.max = v
+		}
+		h.totalCount += n
+
+		fv, fs, fn := float64(v), float64(step), float64(n)
+		sumK := fn * (fn - 1) / 2
+		sumK2 := (fn - 1) * fn * (2*fn - 1) / 6
+		h.sum += fn*fv - fs*sumK
+		h.sumSquares += fn*fv*fv - 2*fv*fs*sumK + fs*fs*sumK2
+
+		v = last - step
+	}
+}
+
+func (h *Histogram) RecordCorrectedDuration(d time.Duration, expectedInterval time.Duration) {
+	h.RecordCorrectedValue(d.Microseconds(), expectedInterval.Microseconds())
+}
+
+// Adds all values recorded in other to h
+func (h *Histogram) Merge(other *Histogram) {
+	if other.totalCount == 0 {
+		return
+	}
+
+	// All values are between min and max
+	for i := histIndex(other.min); i <= histIndex(other.max); i++ {
+		h.counts[i] += other.counts[i]
+	}
+
+	if h.totalCount == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.totalCount += other.totalCount
+	h.sum += other.sum
+	h.sumSquares += other.sumSquares
+}
+
+func (h *Histogram) Reset() {
+	// Only the buckets between min and max can be non-zero
+	if h.totalCount > 0 {
+		lo, hi := histIndex(h.min), histIndex(h.max)
+		for i := lo; i <= hi; i++ {
+			h.counts[i] = 0
+		}
+	}
+	h.totalCount = 0
+	h.min = 0
+	h.max = 0
+	h.sum = 0
+	h.sumSquares = 0
+}
+
+func (h *Histogram) Count() int64 {
+	return h.totalCount
+}
+
+// Min recorded value in microseconds
+func (h *Histogram) Min() int64 {
+	return h.min
+}
+
+// Max recorded value in microseconds
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean of the recorded values in microseconds
+func (h *Histogram) Mean() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	return h.sum / float64(h.totalCount)
+}
+
+// Standard deviation of the recorded values in microseconds
+func (h *Histogram) StdDev() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	mean := h.Mean()
+	variance := h.sumSquares/float64(h.totalCount) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+
+	return math.Sqrt(variance)
+}
+
+// Returns the value in microseconds that the given percentile (0-100) of the values are at or below
+func (h *Histogram) ValueAtPercentile(p float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	target := int64(math.Ceil(p / 100 * float64(h.totalCount)))
+	if target < 1 {
+		target = 1
+	}
+
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		if n >= target {
+			// Bucket upper bounds can overshoot the exact extremes
+			v := histValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+
+	return h.max
+}
+
+// Calls f for each non-empty bucket with its highest value in microseconds
+func (h *Histogram) ForEachBucket(f func(us int64, count int64)) {
+	for i, c := range h.counts {
+		if c > 0 {
+			f(histValue(i), c)
+		}
+	}
+}
+
+type histogramJSON struct {
+	SubBucketBits int        `json:"sub_bucket_bits"`
+	MaxValueBits  int        `json:"max_value_bits"`
+	TotalCount    int64      `json:"total_count"`
+	Min           int64      `json:"min"`
+	Max           int64      `json:"max"`
+	Sum           float64    `json:"sum"`
+	SumSquares    float64    `json:"sum_squares"`
+	Counts        [][2]int64 `json:"counts"`
+}
+
+// Serializes the histogram with sparse [index, count] pairs
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	hj := histogramJSON{
+		SubBucketBits: histSubBucketBits,
+		MaxValueBits:  histMaxValueBits,
+		TotalCount:    h.totalCount,
+		Min:           h.min,
+		Max:           h.max,
+		Sum:           h.sum,
+		SumSquares:    h.sumSquares,
+		Counts:        [][2]int64{},
+	}
+
+	for i, c := range h.counts {
+		if c > 0 {
+			hj.Counts = append(hj.Counts, [2]int64{int64(i), c})
+		}
+	}
+
+	return json.Marshal(hj)
+}
+
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	hj := histogramJSON{}
+	if err := json.Unmarshal(data, &hj); err != nil {
+		return err
+	}
+
+	if hj.SubBucketBits != histSubBucketBits || hj.MaxValueBits != histMaxValueBits {
+		return ErrHistogramLayout
+	}
+
+	if hj.Min < 0 || hj.Max < hj.Min || hj.Max > histMaxValue {
+		return ErrHistogramLayout
+	}
+
+	// Merge and Reset rely on all counts being between min and max
+	*h = Histogram{}
+	lo, hi := int64(histIndex(hj.Min)), int64(histIndex(hj.Max))
+	for _, ic := range hj.Counts {
+		if ic[0] < lo || ic[0] > hi {
+			return ErrHistogramLayout
+		}
+		h.counts[ic[0]] = ic[1]
+	}
+	h.totalCount = hj.TotalCount
+	h.min = hj.Min
+	h.max = hj.Max
+	h.sum = hj.Sum
+	h.sumSquares = hj.SumSquares
+
+	return nil
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
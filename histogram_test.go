@@ -0,0 +1,208 @@
+package ltt
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// Checks that got is the exact value or above it by at most the relative error of the histogram
+func checkHistValue(t *testing.T, name string, got int64, exact int64) {
+	t.Helper()
+	if got < exact || float64(got-exact) > float64(exact)/histSubBucketHalf {
+		t.Fatalf("%s = %d, want %d within 1/%d", name, got, exact, histSubBucketHalf)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram()
+	for v := int64(1); v <= 100000; v++ {
+		h.RecordValue(v)
+	}
+
+	if h.Count() != 100000 || h.Min() != 1 || h.Max() != 100000 {
+		t.Fatalf("count, min, max = %d, %d, %d", h.Count(), h.Min(), h.Max())
+	}
+	if m := h.Mean(); m != 50000.5 {
+		t.Fatalf("Mean = %f, want 50000.5", m)
+	}
+	for _, p := range []float64{1, 50, 75, 95, 99, 99.9} {
+		checkHistValue(t, "ValueAtPercentile", h.ValueAtPercentile(p), int64(p*1000))
+	}
+	if v := h.ValueAtPercentile(100); v != 100000 {
+		t.Fatalf("ValueAtPercentile(100) = %d, want the max", v)
+	}
+	if v := h.ValueAtPercentile(0); v != 1 {
+		t.Fatalf("ValueAtPercentile(0) = %d, want the min", v)
+	}
+}
+
+func TestHistogramSmallValuesAreExact(t *testing.T) {
+	h := NewHistogram()
+	for v := int64(0); v < histSubBucketCount; v++ {
+		h.RecordValue(v)
+	}
+
+	for v := int64(0); v < histSubBucketCount; v++ {
+		p := float64(v+1) / histSubBucketCount * 100
+		if got := h.ValueAtPercentile(p); got != v {
+			t.Fatalf("ValueAtPercentile(%f) = %d, want %d", p, got, v)
+		}
+	}
+}
+
+func TestHistogramClampsValues(t *testing.T) {
+	h := NewHistogram()
+	h.RecordValue(-5)
+	h.RecordValue(histMaxValue * 2)
+
+	if h.Min() != 0 || h.Max() != histMaxValue {
+		t.Fatalf("min, max = %d, %d, want 0, %d", h.Min(), h.Max(), int64(histMaxValue))
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := NewHistogram(), NewHistogram(), NewHistogram()
+	for v := int64(1); v <= 1000; v++ {
+		a.RecordValue(v * 3)
+		all.RecordValue(v * 3)
+	}
+	for v := int64(1); v <= 500; v++ {
+		b.RecordValue(v * 1000)
+		all.RecordValue(v * 1000)
+	}
+
+	a.Merge(b)
+	// Merging an empty histogram changes nothing
+	a.Merge(NewHistogram())
+
+	if a.Count() != all.Count() || a.Min() != all.Min() || a.Max() != all.Max() || a.Mean() != all.Mean() {
+		t.Fatalf("merged count, min, max, mean = %d, %d, %d, %f, want %d, %d, %d, %f",
+			a.Count(), a.Min(), a.Max(), a.Mean(), all.Count(), all.Min(), all.Max(), all.Mean())
+	}
+	if a.counts != all.counts {
+		t.Fatal("merged counts differ from the counts of recording all values")
+	}
+
+	// Merging into an empty histogram copies it
+	c := NewHistogram()
+	c.Merge(b)
+	if c.Min() != b.Min() || c.counts != b.counts {
+		t.Fatal("merge into an empty histogram differs from the merged histogram")
+	}
+}
+
+func TestHistogramReset(t *testing.T) {
+	h := NewHistogram()
+	h.RecordValue(10)
+	h.RecordValue(1000000)
+	h.Reset()
+
+	if h.Count() != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 {
+		t.Fatal("histogram is not empty after Reset")
+	}
+	if h.counts != (Histogram{}).counts {
+		t.Fatal("counts are not zero after Reset")
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := NewHistogram()
+	for v := int64(1); v <= 10000; v += 7 {
+		h.RecordValue(v)
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := NewHistogram()
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if *out != *h {
+		t.Fatal("unmarshalled histogram differs from the marshalled one")
+	}
+}
+
+func TestHistogramJSONRejectsInvalidLayouts(t *testing.T) {
+	for _, data := range []string{
+		`{"sub_bucket_bits":8,"max_value_bits":36}`,
+		`{"sub_bucket_bits":7,"max_value_bits":36,"total_count":1,"min":10,"max":5}`,
+		`{"sub_bucket_bits":7,"max_value_bits":36,"total_count":1,"min":-1,"max":5}`,
+		`{"sub_bucket_bits":7,"max_value_bits":36,"total_count":1,"min":5,"max":5,"counts":[[6,1]]}`,
+		`{"sub_bucket_bits":7,"max_value_bits":36,"total_count":1,"min":5,"max":5,"counts":[[-1,1]]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), NewHistogram()); err == nil {
+			t.Fatalf("no error for %s", data)
+		}
+	}
+}
+
+// Back-fills the way HdrHistogram does, one value at a time
+func recordCorrectedNaive(h *Histogram, us int64, expectedInterval int64) {
+	if us > histMaxValue {
+		us = histMaxValue
+	}
+
+	h.RecordValue(us)
+	if expectedInterval <= 0 {
+		return
+	}
+	for v := us - expectedInterval; v >= expectedInterval; v -= expectedInterval {
+		h.RecordValue(v)
+	}
+}
+
+func TestHistogramRecordCorrectedValue(t *testing.T) {
+	for _, c := range []struct{ us, interval int64 }{
+		{100, 1000},
+		{1000, 1000},
+		{1001, 1000},
+		{10000, 1000},
+		{123456, 7},
+		{5000000, 333},
+		{200, 1},
+		{50000, 0},
+	} {
+		h, naive := NewHistogram(), NewHistogram()
+		h.RecordCorrectedValue(c.us, c.interval)
+		recordCorrectedNaive(naive, c.us, c.interval)
+
+		if h.counts != naive.counts || h.Count() != naive.Count() || h.Min() != naive.Min() || h.Max() != naive.Max() {
+			t.Fatalf("RecordCorrectedValue(%d, %d): count, min, max = %d, %d, %d, want %d, %d, %d",
+				c.us, c.interval, h.Count(), h.Min(), h.Max(), naive.Count(), naive.Min(), naive.Max())
+		}
+		if math.Abs(h.Mean()-naive.Mean()) > 1e-6*naive.Mean() || math.Abs(h.StdDev()-naive.StdDev()) > 1e-3*naive.StdDev()+1e-6 {
+			t.Fatalf("RecordCorrectedValue(%d, %d): mean, stddev = %f, %f, want %f, %f",
+				c.us, c.interval, h.Mean(), h.StdDev(), naive.Mean(), naive.StdDev())
+		}
+	}
+}
+
+func TestHistogramRecordCorrectedValueAddsToRecorded(t *testing.T) {
+	h := NewHistogram()
+	h.RecordValue(3)
+	h.RecordCorrectedValue(10000, 2500)
+
+	// 10000, 7500, 5000 and 2500 are recorded after 3
+	if h.Count() != 5 || h.Min() != 3 || h.Max() != 10000 {
+		t.Fatalf("count, min, max = %d, %d, %d, want 5, 3, 10000", h.Count(), h.Min(), h.Max())
+	}
+	if m := h.Mean(); m != 25003.0/5 {
+		t.Fatalf("Mean = %f, want %f", m, 25003.0/5)
+	}
+}
+
+// The worst case, the max value with a 1us interval back-fills ~69 billion values
+// in every bucket
+func BenchmarkHistogramRecordCorrectedValue(b *testing.B) {
+	h := NewHistogram()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.RecordCorrectedValue(histMaxValue, 1)
+	}
+}
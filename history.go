@@ -0,0 +1,164 @@
+package ltt
+
+import (
+	"math"
+	"time"
+)
+
+// Default number of snapshots kept in the stats history
+const DefaultHistorySize = 3600
+
+// Task stats of a single history interval, durations in milliseconds
+type TaskSnapshot struct {
+	RPS               float64 `json:"rps"`
+	FailuresPerSecond float64 `json:"failures_per_second"`
+	P50               float64 `json:"p50"`
+	P95               float64 `json:"p95"`
+	P99               float64 `json:"p99"`
+}
+
+// Stats of a single history interval
+type StatsSnapshot struct {
+	Time              time.Time                `json:"time"`
+	RPS               float64                  `json:"rps"`
+	FailuresPerSecond float64                  `json:"failures_per_second"`
+	RunningUsers      int                      `json:"num_users"`
+	P50               float64                  `json:"p50"`
+	P95               float64                  `json:"p95"`
+	P99               float64                  `json:"p99"`
+	Tasks             map[string]*TaskSnapshot `json:"tasks"`
+}
+
+// Results collected since the last snapshot
+type intervalStats struct {
+	NumTotal  int64
+	NumFailed int64
+	Histogram *Histogram
+}
+
+func (is *intervalStats) Record(tr *TaskRun) {
+	is.NumTotal++
+	if tr.Error != nil {
+		is.NumFailed++
+	}
+	is.Histogram.RecordDuration(tr.Duration)
+}
+
+func (is *intervalStats) Merge(other *intervalStats) {
+	is.NumTotal += other.NumTotal
+	is.NumFailed += other.NumFailed
+	is.Histogram.Merge(other.Histogram)
+}
+
+func (is *intervalStats) Reset() {
+	is.NumTotal = 0
+	is.NumFailed = 0
+	is.Histogram.Reset()
+}
+
+func newIntervalStats() *intervalStats {
+	return &intervalStats{Histogram: NewHistogram()}
+}
+
+// Ring buffer of stats snapshots, the oldest snapshot is overwritten when full
+type StatsHistory struct {
+	snapshots []*StatsSnapshot
+	next      int
+	full      bool
+}
+
+func (h *StatsHistory) Add(s *StatsSnapshot) {
+	if len(h.snapshots) == 0 {
+		return
+	}
+
+	h.snapshots[h.next] = s
+	h.next = (h.next + 1) % len(h.snapshots)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Returns the snapshots, oldest first
+func (h *StatsHistory) Snapshots() []*StatsSnapshot {
+	if !h.full {
+		return append([]*StatsSnapshot{}, h.snapshots[:h.next]...)
+	}
+
+	return append(append([]*StatsSnapshot{}, h.snapshots[h.next:]...), h.snapshots[:h.next]...)
+}
+
+func (h *StatsHistory) Reset() {
+	for i := range h.snapshots {
+		h.snapshots[i] = nil
+	}
+	h.next = 0
+	h.full = false
+}
+
+func NewStatsHistory(size int) *StatsHistory {
+	return &StatsHistory{snapshots: make([]*StatsSnapshot, size)}
+}
+
+// Merges the snapshots into buckets of the given step, for long running tests.
+// Rates are averaged, percentiles take the max of the bucket and users the last value.
+func DownsampleHistory(snapshots []*StatsSnapshot, step time.Duration) []*StatsSnapshot {
+	if step <= 0 || len(snapshots) == 0 {
+		return snapshots
+	}
+
+	result := []*StatsSnapshot{}
+	var bucket *StatsSnapshot
+	var n float64
+	taskCounts := map[string]float64{}
+
+	flush := func() {
+		if bucket == nil {
+			return
+		}
+
+		bucket.RPS /= n
+		bucket.FailuresPerSecond /= n
+		for name, t := range bucket.Tasks {
+			t.RPS /= taskCounts[name]
+			t.FailuresPerSecond /= taskCounts[name]
+		}
+		result = append(result, bucket)
+	}
+
+	for _, s := range snapshots {
+		start := s.Time.Truncate(step)
+		if bucket == nil || !bucket.Time.Equal(start) {
+			flush()
+			bucket = &StatsSnapshot{Time: start, Tasks: map[string]*TaskSnapshot{}}
+			n = 0
+			taskCounts = map[string]float64{}
+		}
+
+		n++
+		bucket.RPS += s.RPS
+		bucket.FailuresPerSecond += s.FailuresPerSecond
+		bucket.RunningUsers = s.RunningUsers
+		bucket.P50 = math.Max(bucket.P50, s.P50)
+		bucket.P95 = math.Max(bucket.P95, s.P95)
+		bucket.P99 = math.Max(bucket.P99, s.P99)
+
+		for name, ts := range s.Tasks {
+			bt, ok := bucket.Tasks[name]
+			if !ok {
+				bt = &TaskSnapshot{}
+				bucket.Tasks[name] = bt
+			}
+
+			taskCounts[name]++
+			bt.RPS += ts.RPS
+			bt.FailuresPerSecond += ts.FailuresPerSecond
+			bt.P50 = math.Max(bt.P50, ts.P50)
+			bt.P95 = math.Max(bt.P95, ts.P95)
+			bt.P99 = math.Max(bt.P99, ts.P99)
+		}
+	}
+	flush()
+
+	return result
+}
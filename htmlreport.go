@@ -0,0 +1,345 @@
+package ltt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+	"time"
+)
+
+// Chart dimensions of the HTML report in pixels
+const (
+	chartWidth   = 900
+	chartHeight  = 260
+	chartPadding = 50
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#d62728", "#2ca02c", "#9467bd"}
+
+type chartPoint struct {
+	X float64
+	Y float64
+}
+
+type chartSeries struct {
+	Name   string
+	Points []chartPoint
+}
+
+func niceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*exp >= v {
+			return m * exp
+		}
+	}
+
+	return 10 * exp
+}
+
+func writeLegend(sb *strings.Builder, names []string) {
+	for i, name := range names {
+		x := chartPadding + i*160
+		color := chartColors[i%len(chartColors)]
+		fmt.Fprintf(sb, `<rect x="%d" y="8" width="12" height="12" fill="%s"/>`, x, color)
+		fmt.Fprintf(sb, `<text x="%d" y="19" font-size="12">%s</text>`, x+16, template.HTMLEscapeString(name))
+	}
+}
+
+// Renders the series as an inline SVG line chart with unix timestamps on the X axis
+func svgLineChart(series []chartSeries, yLabel string) template.HTML {
+	minX, maxX, maxY := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range series {
+		for _, p := range s.Points {
+			minX = math.Min(minX, p.X)
+			maxX = math.Max(maxX, p.X)
+			maxY = math.Max(maxY, p.Y)
+		}
+	}
+
+	if math.IsInf(minX, 1) {
+		return template.HTML(`<p class="empty">No data</p>`)
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	maxY = niceMax(maxY)
+
+	plotW := float64(chartWidth - 2*chartPadding)
+	plotH := float64(chartHeight - 2*chartPadding)
+	scaleX := func(x float64) float64 { return chartPadding + (x-minX)/(maxX-minX)*plotW }
+	scaleY := func(y float64) float64 { return chartPadding + plotH - y/maxY*plotH }
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+
+	// Grid lines and axis labels
+	for i := 0; i <= 4; i++ {
+		y := maxY * float64(i) / 4
+		fmt.Fprintf(sb, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" stroke="#ddd"/>`,
+			chartPadding, chartWidth-chartPadding, scaleY(y), scaleY(y))
+		fmt.Fprintf(sb, `<text x="%d" y="%.1f" font-size="11" text-anchor="end">%s</text>`,
+			chartPadding-4, scaleY(y)+4, formatFloat(y))
+	}
+	for i := 0; i <= 4; i++ {
+		x := minX + (maxX-minX)*float64(i)/4
+		label := time.Unix(int64(x), 0).Format("15:04:05")
+		fmt.Fprintf(sb, `<text x="%.1f" y="%d" font-size="11" text-anchor="middle">%s</text>`,
+			scaleX(x), chartHeight-chartPadding+16, label)
+	}
+	fmt.Fprintf(sb, `<text x="12" y="%d" font-size="11" transform="rotate(-90 12 %d)" text-anchor="middle">%s</text>`,
+		chartHeight/2, chartHeight/2, template.HTMLEscapeString(yLabel))
+
+	names := []string{}
+	for i, s := range series {
+		names = append(names, s.Name)
+		points := make([]string, 0, len(s.Points))
+		for _, p := range s.Points {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", scaleX(p.X), scaleY(p.Y)))
+		}
+		fmt.Fprintf(sb, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`,
+			chartColors[i%len(chartColors)], strings.Join(points, " "))
+	}
+	writeLegend(sb, names)
+
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// Renders the latency distribution of the histogram as an inline SVG bar chart
+// with log2 sized buckets in milliseconds
+func svgDistributionChart(h *Histogram) template.HTML {
+	if h.Count() == 0 {
+		return template.HTML(`<p class="empty">No data</p>`)
+	}
+
+	// Bucket upper bound in ms -> count, the first bucket is <= 1ms
+	bounds := []float64{}
+	counts := []int64{}
+	h.ForEachBucket(func(us int64, count int64) {
+		ms := float64(us) / 1000
+		bound := 1.0
+		for bound < ms {
+			bound *= 2
+		}
+
+		if len(bounds) == 0 || bounds[len(bounds)-1] != bound {
+			bounds = append(bounds, bound)
+			counts = append(counts, 0)
+		}
+		counts[len(counts)-1] += count
+	})
+
+	var maxCount int64
+	for _, c := range counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	plotW := float64(chartWidth - 2*chartPadding)
+	plotH := float64(chartHeight - 2*chartPadding)
+	barW := plotW / float64(len(counts))
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+	for i, c := range counts {
+		hgt := float64(c) / float64(maxCount) * plotH
+		x := chartPadding + float64(i)*barW
+		fmt.Fprintf(sb, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>&lt;= %s ms: %d</title></rect>`,
+			x+1, chartPadding+plotH-hgt, math.Max(barW-2, 1), hgt, chartColors[0], formatFloat(bounds[i]), c)
+		fmt.Fprintf(sb, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%s</text>`,
+			x+barW/2, chartHeight-chartPadding+14, formatFloat(bounds[i]))
+	}
+	fmt.Fprintf(sb, `<text x="%d" y="%d" font-size="11" text-anchor="middle">ms (bucket upper bound)</text>`,
+		chartWidth/2, chartHeight-chartPadding+32)
+	sb.WriteString(`</svg>`)
+
+	return template.HTML(sb.String())
+}
+
+type htmlReportData struct {
+	Report      *Report
+	ConfigJSON  string
+	Tasks       []*TaskStats
+	Phases      []*PhaseStats
+	Requests    []*RequestStats
+	Percentiles []int
+	Corrected   bool
+	Charts      map[string]template.HTML
+}
+
+// Renders the report as a single HTML file with inline styles and SVG charts,
+// so that it can be viewed offline
+func (r *Report) HTML() ([]byte, error) {
+	conf, err := json.MarshalIndent(r.Config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var p50, p95, p99, rps, fps, users []chartPoint
+	for _, s := range r.History {
+		x := float64(s.Time.Unix())
+		p50 = append(p50, chartPoint{x, s.P50})
+		p95 = append(p95, chartPoint{x, s.P95})
+		p99 = append(p99, chartPoint{x, s.P99})
+		rps = append(rps, chartPoint{x, s.RPS})
+		fps = append(fps, chartPoint{x, s.FailuresPerSecond})
+		users = append(users, chartPoint{x, float64(s.RunningUsers)})
+	}
+
+	data := htmlReportData{
+		Report:      r,
+		ConfigJSON:  string(conf),
+		Tasks:       r.SortedTasks(),
+		Phases:      r.SortedPhases(),
+		Requests:    r.SortedRequests(),
+		Percentiles: reportPercentiles,
+		Corrected:   r.Aggregated.CorrectedHistogram != nil,
+		Charts: map[string]template.HTML{
+			"latency":      svgLineChart([]chartSeries{{"p50", p50}, {"p95", p95}, {"p99", p99}}, "ms"),
+			"throughput":   svgLineChart([]chartSeries{{"Requests/s", rps}, {"Failures/s", fps}}, "per second"),
+			"users":        svgLineChart([]chartSeries{{"Users", users}}, "users"),
+			"distribution": svgDistributionChart(r.Aggregated.Histogram),
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := htmlReportTemplate.Execute(buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"apdex": formatApdex, "corrected": formatCorrectedPercentile}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ltt report {{.Report.GeneratedAt.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tr.aggregated { font-weight: bold; background: #f4f4f4; }
+pre { background: #f4f4f4; padding: 1em; }
+.empty { color: #888; }
+</style>
+</head>
+<body>
+<h1>Load test report</h1>
+<p>Generated {{.Report.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}, status {{.Report.Status}}</p>
+
+<h2>Totals</h2>
+<table>
+<tr><th>Start time</th><td>{{.Report.Stats.StartTime.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>End time</th><td>{{.Report.Stats.EndTime.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Duration (s)</th><td>{{printf "%.0f" .Report.Duration}}</td></tr>
+<tr><th>Users</th><td>{{.Report.Stats.RunningUsers}}</td></tr>
+<tr><th>Requests</th><td>{{.Report.Stats.NumTotal}}</td></tr>
+<tr><th>Successful</th><td>{{.Report.Stats.NumSuccessful}}</td></tr>
+<tr><th>Failed</th><td>{{.Report.Stats.NumFailed}}</td></tr>
+<tr><th>Average duration (ms)</th><td>{{printf "%.2f" .Report.Stats.AverageDuration}}</td></tr>
+<tr><th>Mean RPS</th><td>{{printf "%.2f" .Report.Stats.MeanRPS}}</td></tr>
+<tr><th>Peak RPS</th><td>{{printf "%.2f" .Report.Stats.PeakRPS}}</td></tr>
+<tr><th>Bytes sent</th><td>{{.Report.Stats.BytesSent}}</td></tr>
+<tr><th>Bytes received</th><td>{{.Report.Stats.BytesReceived}}</td></tr>
+</table>
+
+<h2>Tasks</h2>
+<table>
+<tr><th>Name</th><th>Requests</th><th>Failures</th><th>Avg (ms)</th><th>Min (ms)</th><th>Max (ms)</th><th>StdDev (ms)</th>
+{{- range .Percentiles}}<th>{{.}}%</th>{{end}}<th>Apdex</th>
+{{- if .Corrected}}{{range .Percentiles}}<th>Corrected {{.}}%</th>{{end}}{{end}}</tr>
+{{- range $t := .Tasks}}
+<tr><td>{{$t.Name}}</td><td>{{$t.TotalRuns}}</td><td>{{$t.NumFailed}}</td><td>{{printf "%.2f" $t.AverageDuration}}</td>
+<td>{{printf "%.2f" $t.MinDuration}}</td><td>{{printf "%.2f" $t.MaxDuration}}</td><td>{{printf "%.2f" $t.StdDevDuration}}</td>
+{{- range $.Percentiles}}<td>{{index $t.Percentiles .}}</td>{{end}}<td>{{apdex $t}}</td>
+{{- if $.Corrected}}{{range $.Percentiles}}<td>{{corrected $t .}}</td>{{end}}{{end}}</tr>
+{{- end}}
+{{- with .Report.Aggregated}}
+<tr class="aggregated"><td>{{.Name}}</td><td>{{.TotalRuns}}</td><td>{{.NumFailed}}</td><td>{{printf "%.2f" .AverageDuration}}</td>
+<td>{{printf "%.2f" .MinDuration}}</td><td>{{printf "%.2f" .MaxDuration}}</td><td>{{printf "%.2f" .StdDevDuration}}</td>
+{{- $agg := .}}{{range $.Percentiles}}<td>{{index $agg.Percentiles .}}</td>{{end}}<td>{{apdex $agg}}</td>
+{{- if $.Corrected}}{{range $.Percentiles}}<td>{{corrected $agg .}}</td>{{end}}{{end}}</tr>
+{{- end}}
+</table>
+
+<h2>Requests</h2>
+<table>
+<tr><th>Name</th><th>Requests</th><th>Failures</th><th>Bytes sent</th><th>Bytes received</th><th>Body bytes sent</th><th>Body bytes received</th></tr>
+{{- range .Requests}}
+<tr><td>{{.Name}}</td><td>{{.NumRequests}}</td><td>{{.NumFailed}}</td><td>{{.BytesSent}}</td><td>{{.BytesReceived}}</td>
+<td>{{.BodyBytesSent}}</td><td>{{.BodyBytesReceived}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Status codes</h2>
+<table>
+<tr><th>Name</th><th>Status codes</th></tr>
+{{- range $t := .Tasks}}
+<tr><td>{{$t.Name}}</td><td style="text-align:left">{{template "status_codes" $t.StatusCodes}}</td></tr>
+{{- end}}
+{{- range $r := .Requests}}
+<tr><td>{{$r.Name}}</td><td style="text-align:left">{{template "status_codes" $r.StatusCodes}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Request timings</h2>
+<table>
+<tr><th>Name</th><th>DNS (ms)</th><th>Connect (ms)</th><th>TLS (ms)</th><th>TTFB (ms)</th><th>Transfer (ms)</th>
+<th>New connections</th><th>Reused connections</th><th>Reuse ratio</th></tr>
+{{- range $r := .Requests}}
+<tr><td>{{$r.Name}}</td>{{template "timings" $r.Connections}}</tr>
+{{- end}}
+{{- with .Report.Stats.Connections}}
+<tr class="aggregated"><td>Aggregated</td>{{template "timings" .}}</tr>
+{{- end}}
+</table>
+
+<h2>Phases</h2>
+<table>
+<tr><th>Phase</th><th>Start time</th><th>End time</th><th>Requests</th><th>Successful</th><th>Failed</th><th>Avg (ms)</th></tr>
+{{- range .Phases}}
+<tr><td>{{.Name}}</td><td>{{.StartTime.Format "15:04:05"}}</td><td>{{.EndTime.Format "15:04:05"}}</td>
+<td>{{.NumTotal}}</td><td>{{.NumSuccessful}}</td><td>{{.NumFailed}}</td><td>{{printf "%.2f" .AverageDuration}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Errors</h2>
+<table>
+<tr><th>Task</th><th>Error</th><th>Occurrences</th></tr>
+{{- range $t := .Tasks}}{{range $e, $c := $t.Errors}}
+<tr><td>{{$t.Name}}</td><td style="text-align:left">{{$e}}</td><td>{{$c}}</td></tr>
+{{- end}}{{end}}
+</table>
+
+<h2>Latency over time</h2>
+{{index .Charts "latency"}}
+
+<h2>Throughput over time</h2>
+{{index .Charts "throughput"}}
+
+<h2>Users over time</h2>
+{{index .Charts "users"}}
+
+<h2>Latency distribution</h2>
+{{index .Charts "distribution"}}
+
+<h2>Config</h2>
+<pre>{{.ConfigJSON}}</pre>
+</body>
+</html>
+{{define "status_codes"}}{{range $code, $c := .}}{{$code}}: {{$c}} {{end}}{{end}}
+{{define "timings"}}<td>{{printf "%.2f" .DNS.Average}}</td><td>{{printf "%.2f" .Connect.Average}}</td><td>{{printf "%.2f" .TLS.Average}}</td>
+<td>{{printf "%.2f" .TTFB.Average}}</td><td>{{printf "%.2f" .Transfer.Average}}</td>
+<td>{{.NewConnections}}</td><td>{{.ReusedConnections}}</td><td>{{printf "%.2f" .ReuseRatio}}</td>{{end}}
+`))
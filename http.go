@@ -2,9 +2,11 @@ package ltt
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -21,7 +23,7 @@ var httpClientContextKey httpClientContextKeyType
 func NewHTTPClient(ctx context.Context, baseURI string) *HTTPClient {
 	jar, err := cookiejar.New(&cookiejar.Options{})
 	if err != nil {
-		log.Fatalf("failed to create cookie jar: %w", err)
+		log.Fatalf("failed to create cookie jar: %s", err)
 	}
 
 	lt := FromContext(ctx)
@@ -35,6 +37,13 @@ func NewHTTPClient(ctx context.Context, baseURI string) *HTTPClient {
 		user:             UserFromContext(ctx),
 		Headers:          make(http.Header),
 		ErrorOnErrorCode: true,
+
+		PropagateTraceContext: lt.Config.TraceContext,
+	}
+
+	if sess := SessionFromContext(ctx); sess != nil {
+		sess.clients = append(sess.clients, client)
+		sess.restoreClient(client)
 	}
 
 	return client
@@ -60,8 +69,43 @@ type HTTPClient struct {
 	user User
 	// These headers will be set in all requests
 	Headers http.Header
-	// If true, 4xx-5xx status code will return an error, defaults to true
+	// If true, 4xx-5xx status code will return an *HTTPError, defaults to true. If
+	// false, the response is returned without an error.
 	ErrorOnErrorCode bool
+	// If true, a W3C traceparent header is set with the trace ID of the current
+	// task run, defaults to Config.TraceContext. If Config.TraceContext is off, the
+	// run gets a trace ID on its first request with the client.
+	PropagateTraceContext bool
+}
+
+// Sets the default headers the request doesn't have, e.g. the Content-Type of
+// PostForm takes precedence, and the traceparent header if enabled
+func (c *HTTPClient) setHeaders(std_req *http.Request) {
+	for k, v := range c.Headers {
+		if _, ok := std_req.Header[k]; !ok {
+			std_req.Header[k] = v
+		}
+	}
+
+	if c.PropagateTraceContext {
+		if ts := traceStateFromContext(c.user.Context()); ts != nil {
+			if traceID := ts.ensure(); traceID != "" {
+				setTraceParent(std_req.Header, traceID)
+			}
+		}
+	}
+}
+
+// Returned for 4xx-5xx responses when ErrorOnErrorCode is set
+type HTTPError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("error status code %d: %s", e.StatusCode, truncate(string(e.Body), MaxErrorSampleSize))
 }
 
 type HTTPResponse struct {
@@ -78,14 +122,59 @@ func (c *HTTPClient) getUrl(path string) string {
 	return fmt.Sprint(strings.TrimRight(c.baseURI, "/"), path)
 }
 
-func (c *HTTPClient) handleResponse(method string, path string, std_resp *http.Response) (*HTTPResponse, error) {
-	response_body, err := ioutil.ReadAll(std_resp.Body)
+// Returns the request name used when none is given, the method and path without the query
+func requestName(method string, path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	return method + " " + path
+}
+
+// Reads the response body, decoding it if the client requested compression
+func readBody(std_resp *http.Response, decode bool, rr *RequestRun) ([]byte, error) {
+	raw := &countingReader{r: std_resp.Body}
+	var body io.Reader = raw
+	if decode && std_resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(raw)
+		if err != nil && err != io.EOF {
+			return nil, err
+		} else if err == nil {
+			defer gz.Close()
+			body = gz
+		}
+
+		// The body is returned decoded, as the transport does
+		std_resp.Header.Del("Content-Encoding")
+		std_resp.Header.Del("Content-Length")
+		std_resp.ContentLength = -1
+		std_resp.Uncompressed = true
+	}
+
+	data, err := ioutil.ReadAll(body)
+	rr.BytesReceived += raw.n
+	rr.BodyBytesReceived = int64(len(data))
+
+	return data, err
+}
+
+func (c *HTTPClient) handleResponse(method string, path string, std_resp *http.Response, decode bool, rr *RequestRun) (*HTTPResponse, error) {
+	defer std_resp.Body.Close()
+
+	rr.StatusCode = std_resp.StatusCode
+	rr.BytesReceived = responseHeaderSize(std_resp)
+	response_body, err := readBody(std_resp, decode, rr)
 	if err != nil {
 		return nil, err
 	}
 
-	if std_resp.StatusCode >= http.StatusBadRequest {
-		return nil, fmt.Errorf("error status code %d: %s", std_resp.StatusCode, string(response_body))
+	if c.ErrorOnErrorCode && std_resp.StatusCode >= http.StatusBadRequest {
+		return nil, &HTTPError{
+			StatusCode: std_resp.StatusCode,
+			Method:     method,
+			Path:       path,
+			Body:       response_body,
+		}
 	}
 
 	resp := &HTTPResponse{
@@ -103,28 +192,65 @@ func (c *HTTPClient) handleResponse(method string, path string, std_resp *http.R
 	return resp, nil
 }
 
-func (c *HTTPClient) Request(method string, path string, body []byte) (*HTTPResponse, error) {
-	lt := FromContext(c.user.Context())
-	if FromContext(c.user.Context()).Config.Verbose {
-		lt.Log.Printf("HTTPClient(user %d): requesting %s %s\n", c.user.ID(), method, path)
+// Sends the request and records it in the user's current task run
+func (c *HTTPClient) do(name string, path string, std_req *http.Request, bodySize int64) (*HTTPResponse, error) {
+	c.setHeaders(std_req)
+
+	// Compression is requested like the transport does, but decoded here to count the encoded size
+	decode := false
+	if std_req.Method != http.MethodHead && std_req.Header.Get("Accept-Encoding") == "" && std_req.Header.Get("Range") == "" {
+		std_req.Header.Set("Accept-Encoding", "gzip")
+		decode = true
 	}
 
-	std_req, err := http.NewRequest(method, c.getUrl(path), bytes.NewReader(body))
+	rr := &RequestRun{
+		Name:      name,
+		Method:    std_req.Method,
+		StartTime: time.Now(),
+	}
+	rr.BodyBytesSent = bodySize
+	rr.BytesSent = requestHeaderSize(std_req) + bodySize
+
+	std_req, rt := traceRequest(std_req)
+	resp, err := c.sendRequest(std_req, path, decode, rr)
+	rr.Duration = time.Now().Sub(rr.StartTime)
+	rr.Timing = rt.finish()
+	rr.Error = err
+
+	if rl := requestLogFromContext(c.user.Context()); rl != nil {
+		rl.add(rr)
+	}
+
+	return resp, err
+}
+
+func (c *HTTPClient) sendRequest(std_req *http.Request, path string, decode bool, rr *RequestRun) (*HTTPResponse, error) {
+	std_resp, err := c.std.Do(std_req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Set the default headers
-	for k, v := range c.Headers {
-		std_req.Header[k] = v
+	return c.handleResponse(std_req.Method, path, std_resp, decode, rr)
+}
+
+func (c *HTTPClient) Request(method string, path string, body []byte) (*HTTPResponse, error) {
+	return c.RequestNamed(requestName(method, path), method, path, body)
+}
+
+// Like Request, but the request's stats are aggregated under the name, e.g.
+// "GET /users/:id" for paths that contain IDs
+func (c *HTTPClient) RequestNamed(name string, method string, path string, body []byte) (*HTTPResponse, error) {
+	lt := FromContext(c.user.Context())
+	if FromContext(c.user.Context()).Config.Verbose {
+		lt.Log.Printf("HTTPClient(user %d): requesting %s %s\n", c.user.ID(), method, path)
 	}
 
-	std_resp, err := c.std.Do(std_req)
+	std_req, err := http.NewRequest(method, c.getUrl(path), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
-	return c.handleResponse(method, path, std_resp)
+	return c.do(name, path, std_req, int64(len(body)))
 }
 
 func (c *HTTPClient) Get(path string) (*HTTPResponse, error) {
@@ -153,24 +279,15 @@ func (c *HTTPClient) PostForm(path string, data url.Values) (*HTTPResponse, erro
 		lt.Log.Printf("HTTPClient(user %d): requesting %s %s\n", c.user.ID(), http.MethodPost, path)
 	}
 
-	std_req, err := http.NewRequest(http.MethodPost, c.getUrl(path), strings.NewReader(data.Encode()))
+	body := data.Encode()
+	std_req, err := http.NewRequest(http.MethodPost, c.getUrl(path), strings.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
-	// Set the default headers
-	for k, v := range c.Headers {
-		std_req.Header[k] = v
-	}
-
 	std_req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	std_resp, err := c.std.Do(std_req)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.handleResponse(http.MethodPost, path, std_resp)
+	return c.do(requestName(http.MethodPost, path), path, std_req, int64(len(body)))
 }
 
 func (c *HTTPClient) Patch(path string, body []byte) (*HTTPResponse, error) {
@@ -0,0 +1,42 @@
+package ltt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPostFormContentTypeOverridesDefaultHeaders(t *testing.T) {
+	var contentType, accept string
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		accept = r.Header.Get("Accept")
+		r.ParseForm()
+		form = r.PostForm
+	}))
+	defer srv.Close()
+
+	lt := NewLoadTest(Config{RequestTimeout: 5})
+	du := NewDefaultUser(nil)
+	du.SetContext(NewUserContext(NewLoadTestContext(context.Background(), lt), du))
+	client := NewHTTPClient(du.Context(), srv.URL)
+	client.Headers.Set("Content-Type", "application/json")
+	client.Headers.Set("Accept", "application/json")
+
+	if _, err := client.PostForm("/login", url.Values{"user": {"a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/x-www-form-urlencoded" {
+		t.Fatalf("Content-Type = %q, want the form content type", contentType)
+	}
+	if accept != "application/json" {
+		t.Fatalf("Accept = %q, want the default header", accept)
+	}
+	if form.Get("user") != "a" {
+		t.Fatalf("form = %v", form)
+	}
+}
@@ -0,0 +1,301 @@
+package ltt
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Measurement name of the written points
+	InfluxMeasurement = "ltt_task"
+	// Max size of buffered raw points, points are dropped when it's full
+	MaxInfluxBufferSize = 8 * 1024 * 1024
+	// Max size of an InfluxDB UDP packet
+	influxMaxPacketSize = 1432
+)
+
+var (
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+type influxAggKey struct {
+	task      string
+	userClass string
+	status    string
+}
+
+// Writes task results in the InfluxDB line protocol to a file, UDP socket or
+// HTTP endpoint, either every task run or aggregated per interval
+type InfluxExporter struct {
+	sync.Mutex
+	lt *LoadTest
+	// file:///path, udp://host:port or http(s)://host/api/v2/write?org=..&bucket=..
+	URL       *url.URL
+	Token     string
+	Aggregate bool
+	Interval  time.Duration
+	// Buffered raw points
+	buf     bytes.Buffer
+	dropped int64
+	agg     map[influxAggKey]*intervalStats
+	client  *http.Client
+	// Serializes flushes
+	flushLock sync.Mutex
+}
+
+func influxStatus(tr *TaskRun) string {
+	if tr.Error != nil {
+		return "failure"
+	}
+
+	return "success"
+}
+
+// Returns the measurement and tags of a point, with the tag keys sorted. Tags
+// with empty values are left out, as the line protocol doesn't allow them.
+func (e *InfluxExporter) tags(task string, userClass string, status string, errorClass ErrorClass) string {
+	var b strings.Builder
+	b.WriteString(influxMeasurementEscaper.Replace(InfluxMeasurement))
+	for _, kv := range [][2]string{
+		{"error_class", string(errorClass)},
+		{"run_id", e.lt.Config.RunID},
+		{"status", status},
+		{"task", task},
+		{"user_class", userClass},
+	} {
+		if kv[1] != "" {
+			b.WriteString("," + kv[0] + "=" + influxTagEscaper.Replace(kv[1]))
+		}
+	}
+
+	return b.String()
+}
+
+// Returns the user class of the run, the load test's if the user didn't set it
+func (e *InfluxExporter) userClass(tr *TaskRun) string {
+	if tr.UserClass != "" {
+		return tr.UserClass
+	}
+
+	return e.lt.UserClass()
+}
+
+// Returns the start time of the run, or when it was recorded if the user didn't set it
+func influxTime(tr *TaskRun) time.Time {
+	if !tr.StartTime.IsZero() {
+		return tr.StartTime
+	} else if !tr.recordTime.IsZero() {
+		return tr.recordTime
+	}
+
+	return time.Now()
+}
+
+func (e *InfluxExporter) HandleTaskRun(tr *TaskRun) {
+	name := tr.Task.FullName()
+	status := influxStatus(tr)
+	userClass := e.userClass(tr)
+
+	e.Lock()
+	defer e.Unlock()
+
+	if e.Aggregate {
+		key := influxAggKey{name, userClass, status}
+		is, ok := e.agg[key]
+		if !ok {
+			is = newIntervalStats()
+			e.agg[key] = is
+		}
+		is.Record(tr)
+		return
+	}
+
+	var class ErrorClass
+	if tr.Error != nil {
+		class, _ = ClassifyError(tr.Error)
+	}
+	line := e.tags(name, userClass, status, class)
+	line += fmt.Sprintf(" duration_ms=%s,user_id=%di %d\n",
+		formatMetricFloat(float64(tr.Duration.Microseconds())/1000), tr.UserID, influxTime(tr).UnixNano())
+
+	if e.buf.Len()+len(line) > MaxInfluxBufferSize {
+		e.dropped++
+		return
+	}
+	e.buf.WriteString(line)
+}
+
+// Returns the buffered points, and the aggregated points of the interval
+func (e *InfluxExporter) collect(now time.Time) ([]byte, int64) {
+	e.Lock()
+	defer e.Unlock()
+
+	data := append([]byte{}, e.buf.Bytes()...)
+	dropped := e.dropped
+	e.buf.Reset()
+	e.dropped = 0
+
+	keys := make([]influxAggKey, 0, len(e.agg))
+	for k := range e.agg {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].task != keys[j].task {
+			return keys[i].task < keys[j].task
+		} else if keys[i].userClass != keys[j].userClass {
+			return keys[i].userClass < keys[j].userClass
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	for _, k := range keys {
+		is := e.agg[k]
+		if is.NumTotal == 0 {
+			continue
+		}
+
+		h := is.Histogram
+		line := fmt.Sprintf("%s count=%di,mean_ms=%s,p50_ms=%s,p95_ms=%s,p99_ms=%s,max_ms=%s %d\n",
+			e.tags(k.task, k.userClass, k.status, ""), is.NumTotal,
+			formatMetricFloat(h.Mean()/1000),
+			formatMetricFloat(float64(h.ValueAtPercentile(50))/1000),
+			formatMetricFloat(float64(h.ValueAtPercentile(95))/1000),
+			formatMetricFloat(float64(h.ValueAtPercentile(99))/1000),
+			formatMetricFloat(float64(h.Max())/1000),
+			now.UnixNano())
+		data = append(data, line...)
+		is.Reset()
+	}
+
+	return data, dropped
+}
+
+func (e *InfluxExporter) writeFile(data []byte) error {
+	f, err := os.OpenFile(e.URL.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (e *InfluxExporter) writeUDP(data []byte) error {
+	conn, err := net.Dial("udp", e.URL.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Split into packets on line boundaries
+	for len(data) > 0 {
+		n := len(data)
+		if n > influxMaxPacketSize {
+			n = bytes.LastIndexByte(data[:influxMaxPacketSize], '\n') + 1
+			if n <= 0 {
+				n = bytes.IndexByte(data, '\n') + 1
+			}
+		}
+
+		if _, err := conn.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func (e *InfluxExporter) writeHTTP(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.URL.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.Token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error status code %d: %s", resp.StatusCode, truncate(string(body), MaxErrorSampleSize))
+	}
+
+	return nil
+}
+
+// Writes the buffered and aggregated points
+func (e *InfluxExporter) Flush() error {
+	e.flushLock.Lock()
+	defer e.flushLock.Unlock()
+
+	data, dropped := e.collect(time.Now())
+	if dropped > 0 {
+		e.lt.Log.Printf("InfluxExporter: dropped %d points, the buffer was full\n", dropped)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	switch e.URL.Scheme {
+	case "file":
+		return e.writeFile(data)
+	case "udp":
+		return e.writeUDP(data)
+	case "http", "https":
+		return e.writeHTTP(data)
+	}
+
+	return fmt.Errorf("unsupported influx url scheme: %s", e.URL.Scheme)
+}
+
+// Flushes on the exporter interval, forever
+func (e *InfluxExporter) Run() {
+	for {
+		time.Sleep(e.Interval)
+		if err := e.Flush(); err != nil {
+			e.lt.Log.Printf("InfluxExporter: failed to write to %s: %s\n", e.URL.Redacted(), err.Error())
+		}
+	}
+}
+
+func NewInfluxExporter(lt *LoadTest, rawURL string, aggregate bool, interval time.Duration) (*InfluxExporter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file", "udp", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported influx url scheme: %s", u.Scheme)
+	}
+
+	return &InfluxExporter{
+		lt:        lt,
+		URL:       u,
+		Aggregate: aggregate,
+		Interval:  interval,
+		agg:       make(map[influxAggKey]*intervalStats),
+		client:    &http.Client{Timeout: time.Second * 10},
+	}, nil
+}
@@ -0,0 +1,69 @@
+package ltt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records a run of a custom user, without a start time or user class, and returns the written lines
+func writeCustomUserPoints(t *testing.T, aggregate bool) []string {
+	lt := NewLoadTest(Config{StatsShards: 1, LogOutput: ioutil.Discard})
+	lt.Status = StatusRunning
+	// An empty run ID is left out as well
+	lt.Config.RunID = ""
+	path := filepath.Join(t.TempDir(), "points.lp")
+	e, err := NewInfluxExporter(lt, "file://"+path, aggregate, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt.AddTaskRunHandler(e)
+
+	lt.startStatsShards()
+	lt.RecordTaskRun(&TaskRun{
+		Task:     NewTask("custom", nil, nil, TaskOptions{}),
+		Duration: 5 * time.Millisecond,
+		Error:    errBench,
+	})
+	lt.closeStatsShards()
+
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestInfluxExporterCustomUserRuns(t *testing.T) {
+	for _, aggregate := range []bool{false, true} {
+		start := time.Now()
+		lines := writeCustomUserPoints(t, aggregate)
+		if len(lines) != 1 {
+			t.Fatalf("aggregate=%t: %d lines, want 1", aggregate, len(lines))
+		}
+
+		fields := strings.Split(lines[0], " ")
+		tags := fields[0]
+		if strings.Contains(tags, "=,") || strings.HasSuffix(tags, "=") || strings.Contains(tags, "run_id") {
+			t.Fatalf("aggregate=%t: empty tag values in %q", aggregate, tags)
+		}
+		if !strings.Contains(tags, ",task=custom,") || !strings.HasSuffix(tags, ",user_class=DefaultUser") {
+			t.Fatalf("aggregate=%t: unexpected tags %q", aggregate, tags)
+		}
+
+		ts, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts < start.UnixNano() || ts > time.Now().UnixNano() {
+			t.Fatalf("aggregate=%t: timestamp %d isn't the record time", aggregate, ts)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package ltt
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -51,9 +56,38 @@ const (
 )
 
 type TaskRun struct {
-	Task     *Task
-	Duration time.Duration
-	Error    error
+	Task      *Task
+	StartTime time.Time
+	Duration  time.Duration
+	Error     error
+	UserID    int64
+	// Name of the user type that ran the task
+	UserClass string
+	// Tags of the task and labels of the user class
+	Tags []string
+	// Apdex threshold of the task, 0 if it's not scored
+	ApdexThreshold time.Duration
+	// Expected interval between the user's runs of the task, 0 if the latency isn't corrected
+	ExpectedInterval time.Duration
+	// Requests made by HTTPClients during the run, custom Users collect them with StartRequests and TakeRequests
+	Requests []*RequestRun
+	// Trace ID of the run if Config.TraceContext is enabled or it made a request
+	// with an HTTPClient that has PropagateTraceContext set
+	TraceID string
+	// The phase the run was recorded in, set by RecordTaskRun
+	Phase string
+	// When the run was recorded, the stats count it as finished then
+	recordTime time.Time
+}
+
+// Receives every task run that stats are collected for, e.g. to export the results
+type TaskRunHandler interface {
+	HandleTaskRun(tr *TaskRun)
+}
+
+// Implemented by task run handlers that buffer results and should be flushed on shutdown
+type Flusher interface {
+	Flush() error
 }
 
 type LoadTest struct {
@@ -62,50 +96,88 @@ type LoadTest struct {
 	// Map of created users
 	UserMap     map[int64]User `json:"-"`
 	UserMapLock sync.Mutex     `json:"-"`
-	TaskRunChan chan *TaskRun  `json:"-"`
-	Stats       *Statistics    `json:"stats"`
-	Log         *log.Logger    `json:"-"`
+	// Deprecated: use RecordTaskRun, runs sent to the channel are forwarded to it
+	TaskRunChan chan *TaskRun `json:"-"`
+	Stats       *Statistics   `json:"stats"`
+	Log         *log.Logger   `json:"-"`
 	// Target number of user to spawn
 	TargetUserNum int `json:"target_user_num"`
+	// Test data feeders by name, must be added before Run
+	Feeders map[string]*Feeder `json:"-"`
+	// Storage shared between all users
+	Shared *SharedStorage `json:"-"`
+	// Persisted user sessions, see Config.SessionFile
+	sessions *sessionStore
+	// Handlers that are passed each task run after the stats have been updated,
+	// must be added before Run
+	TaskRunHandlers []TaskRunHandler `json:"-"`
+	// Custom phase set with SetPhase
+	phase     string
+	phaseLock sync.RWMutex
+	// If true, users are being stopped to reach a lower target, guarded by phaseLock
+	rampingDown bool
+	// Task runs are recorded in shards that are merged into Stats
+	statsShards []*statsShard
+	// Guards recording against the shards being closed on shutdown
+	shardsLock   sync.RWMutex
+	shardsClosed bool
+	shardsDone   sync.WaitGroup
+	// Report to compare the run to, loaded from Config.BaselineFile
+	Baseline *Report `json:"-"`
+	// Log of the task runs if Config.SampleLogFile is set
+	SampleLog *SampleLog `json:"-"`
 }
 
-func (lt *LoadTest) handleTaskRun(tr *TaskRun) {
-	// Only collect stats if we're in a clean running state
-	if lt.Status != StatusRunning {
-		return
+func (lt *LoadTest) AddTaskRunHandler(h TaskRunHandler) {
+	lt.TaskRunHandlers = append(lt.TaskRunHandlers, h)
+}
+
+// Returns the name of the configured user type, used to label results
+func (lt *LoadTest) UserClass() string {
+	if lt.Config.UserType == nil {
+		return "DefaultUser"
 	}
 
-	name := tr.Task.FullName()
-	lt.Stats.Lock()
-	lt.Stats.RPSMap[time.Now().Unix()]++
-	lt.Stats.NumTotal++
-	lt.Stats.TotalDuration += tr.Duration.Milliseconds()
-	if tr.Error != nil {
-		lt.Stats.NumFailed++
-	} else {
-		lt.Stats.NumSuccessful++
+	t := reflect.TypeOf(lt.Config.UserType)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
 
-	if _, ok := lt.Stats.Tasks[name]; !ok {
-		lt.Stats.Tasks[name] = NewTaskStat(name)
+	return t.Name()
+}
+
+// Returns the Apdex threshold of a task, or the Config.ApdexThreshold default
+func (lt *LoadTest) ApdexThreshold(t *Task) time.Duration {
+	if d := t.ApdexThreshold(); d > 0 {
+		return d
 	}
 
-	taskStat := lt.Stats.Tasks[name]
-	lt.Stats.Unlock()
+	return time.Millisecond * time.Duration(lt.Config.ApdexThreshold)
+}
+
+// Returns the expected interval of a task, or the Config.ExpectedInterval default
+func (lt *LoadTest) ExpectedInterval(t *Task) time.Duration {
+	if d := t.ExpectedInterval(); d > 0 {
+		return d
+	}
 
-	taskStat.Lock()
-	durationMS := tr.Duration.Milliseconds()
+	return time.Millisecond * time.Duration(lt.Config.ExpectedInterval)
+}
 
-	taskStat.Metrics[durationMS]++
-	taskStat.TotalRuns++
-	taskStat.TotalDuration += durationMS
-	if tr.Error != nil {
-		taskStat.NumFailed++
-		taskStat.Errors[tr.Error.Error()]++
-	} else {
-		taskStat.NumSuccessful++
+// Returns the labels of the user class from Config.UserLabels
+func (lt *LoadTest) UserLabels() []string {
+	labels := []string{}
+	for _, l := range strings.Split(lt.Config.UserLabels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
 	}
-	taskStat.Unlock()
+
+	return labels
+}
+
+func (lt *LoadTest) AddFeeder(f *Feeder) {
+	lt.Feeders[f.Name] = f
 }
 
 func (lt *LoadTest) usersJob(entryTask *Task) {
@@ -117,6 +189,7 @@ func (lt *LoadTest) usersJob(entryTask *Task) {
 				lt.Status = StatusStopped
 				lt.Stats.EndTime = time.Now()
 				lt.Log.Printf("All users have been stopped, status changed to stopped\n")
+				lt.saveSessions()
 			} else if lt.Stats.RunningUsers == lt.TargetUserNum {
 				lt.Status = StatusRunning
 				lt.Stats.StartTime = time.Now()
@@ -130,6 +203,10 @@ func (lt *LoadTest) usersJob(entryTask *Task) {
 		numUsers := len(lt.UserMap)
 		lt.UserMapLock.Unlock()
 
+		lt.phaseLock.Lock()
+		lt.rampingDown = numUsers > lt.TargetUserNum
+		lt.phaseLock.Unlock()
+
 		var diff int
 		if numUsers > lt.TargetUserNum {
 			if lt.TargetUserNum == 0 {
@@ -161,7 +238,28 @@ func (lt *LoadTest) stopUsers(num int) {
 	}
 }
 
+// Adds the user to UserMap with the lowest ID from from and up that isn't in use and
+// returns the ID. IDs are unique among the users and reused once users are stopped,
+// so that a user spawned again gets the ID, and with it the session, of a stopped one.
+func (lt *LoadTest) addUser(u User, from int64) int64 {
+	lt.UserMapLock.Lock()
+	defer lt.UserMapLock.Unlock()
+
+	id := from
+	for {
+		if _, ok := lt.UserMap[id]; !ok {
+			break
+		}
+		id++
+	}
+
+	u.SetID(id)
+	lt.UserMap[id] = u
+	return id
+}
+
 func (lt *LoadTest) spawnUsers(num int, entryTask *Task) {
+	var nextID int64
 	for i := 0; i < num; i++ {
 		// Create a new User instance
 		var u User
@@ -180,21 +278,32 @@ func (lt *LoadTest) spawnUsers(num int, entryTask *Task) {
 		ctx := NewLoadTestContext(context.Background(), lt)
 		// Save a ref to the user
 		ctx = NewUserContext(ctx, u)
+		// Holds the trace ID of the user's current task run
+		ctx = newTraceStateContext(ctx)
+		// Holds the requests of the user's current task run
+		ctx = newRequestLogContext(ctx)
 		// Setup the user instance's local storage
-		ctx = NewStorageContext(ctx, NewStorage())
+		storage := NewStorage()
+		ctx = NewStorageContext(ctx, storage)
 
-		u.SetID(int64(i))
-		u.SetContext(ctx)
+		nextID = lt.addUser(u, nextID) + 1
 
-		lt.UserMapLock.Lock()
-		lt.UserMap[u.ID()] = u
-		lt.UserMapLock.Unlock()
+		var sess *Session
+		if lt.Config.SessionFile != "" {
+			sess = lt.sessions.take(u.ID())
+			if err := sess.restoreStorage(storage); err != nil {
+				lt.Log.Printf("failed to restore session of user %d: %s\n", u.ID(), err.Error())
+			}
+			ctx = NewSessionContext(ctx, sess)
+		}
+
+		u.SetContext(ctx)
 
-		go func() {
+		go func(i int) {
 			u.SetStatus(UserStatusSpawning)
 
-			// Sleep according to user ID to ramp up the user spawns
-			sleepTime := (int(u.ID()) % lt.TargetUserNum) / lt.Config.NumSpawnPerSecond
+			// Sleep according to the order in the batch to ramp up the user spawns
+			sleepTime := i / lt.Config.NumSpawnPerSecond
 			if lt.Config.Verbose {
 				lt.Log.Printf("pre-spawn sleep time: %d for user %d\n", sleepTime, u.ID())
 			}
@@ -212,6 +321,10 @@ func (lt *LoadTest) spawnUsers(num int, entryTask *Task) {
 				u.Sleep()
 			}
 
+			if sess != nil {
+				lt.sessions.put(u.ID(), sess.capture(StorageFromContext(u.Context())))
+			}
+
 			lt.Stats.Lock()
 			lt.Stats.RunningUsers--
 			lt.Stats.Unlock()
@@ -221,59 +334,274 @@ func (lt *LoadTest) spawnUsers(num int, entryTask *Task) {
 			lt.UserMapLock.Unlock()
 
 			u.SetStatus(UserStatusStopped)
-		}()
+		}(i)
+	}
+}
+
+func (lt *LoadTest) throughputJob() {
+	for {
+		lt.Stats.Lock()
+		lt.MergeStats()
+		lt.Stats.UpdateThroughput(time.Now(), lt.Status == StatusRunning)
+		lt.Stats.Unlock()
+		time.Sleep(time.Second)
 	}
 }
 
-func (lt *LoadTest) cleanRPSJob() {
+func (lt *LoadTest) historyJob() {
+	interval := lt.Config.HistoryInterval
+	if interval <= 0 {
+		interval = 1
+	}
+
 	for {
+		time.Sleep(time.Second * time.Duration(interval))
 		lt.Stats.Lock()
-		lt.Stats.CleanRPSMap()
+		lt.MergeStats()
+		lt.Stats.TakeSnapshot()
 		lt.Stats.Unlock()
-		time.Sleep(time.Second * 30)
+	}
+}
+
+func (lt *LoadTest) writeReports() {
+	if lt.Config.ReportDir == "" {
+		return
+	}
+
+	r, err := lt.NewReport()
+	if err != nil {
+		lt.Log.Printf("failed to create report: %s\n", err.Error())
+		return
+	}
+
+	if err := r.WriteFiles(lt.Config.ReportDir); err != nil {
+		lt.Log.Printf("failed to write reports: %s\n", err.Error())
+	}
+
+	if lt.Baseline != nil {
+		c, err := CompareReports(lt.Baseline, r, lt.Tolerances())
+		var data []byte
+		if err == nil {
+			data, err = json.MarshalIndent(c, "", "  ")
+		}
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(lt.Config.ReportDir, ReportComparisonFile), data, 0644)
+		}
+		if err != nil {
+			lt.Log.Printf("failed to write comparison: %s\n", err.Error())
+		}
+	}
+}
+
+func (lt *LoadTest) reportJob() {
+	interval := lt.Config.ReportInterval
+	if interval <= 0 {
+		interval = 60
+	}
+
+	for {
+		time.Sleep(time.Second * time.Duration(interval))
+		lt.writeReports()
 	}
 }
 
 func (lt *LoadTest) runAPIJob() {
 	err := RunAPIServer(lt)
 	if err != nil {
-		lt.Log.Println("failed to start api server: %s", err.Error())
+		lt.Log.Printf("failed to start api server: %s\n", err.Error())
 	}
 }
 
+// Forwards the runs sent to the deprecated TaskRunChan
 func (lt *LoadTest) taskRunsJob() {
-	for {
+	for tr := range lt.TaskRunChan {
+		lt.RecordTaskRun(tr)
+	}
+}
+
+// Records the runs that are still buffered in TaskRunChan and the shards, so that
+// they're in the final stats and reach the handlers, later runs are dropped
+func (lt *LoadTest) stopRecording() {
+	for len(lt.TaskRunChan) > 0 {
 		select {
 		case tr := <-lt.TaskRunChan:
-			lt.handleTaskRun(tr)
+			lt.RecordTaskRun(tr)
+		default:
+		}
+	}
+
+	lt.closeStatsShards()
+
+	lt.Stats.Lock()
+	lt.MergeStats()
+	lt.Stats.Unlock()
+}
+
+func (lt *LoadTest) flushHandlers() {
+	for _, h := range lt.TaskRunHandlers {
+		if f, ok := h.(Flusher); ok {
+			if err := f.Flush(); err != nil {
+				lt.Log.Printf("failed to flush task run handler: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+func (lt *LoadTest) loadSessions() {
+	if lt.Config.SessionFile == "" {
+		return
+	}
+
+	maxAge := time.Second * time.Duration(lt.Config.SessionMaxAge)
+	if err := lt.sessions.load(lt.Config.SessionFile, maxAge); err != nil {
+		lt.Log.Printf("failed to load sessions: %s\n", err.Error())
+	}
+}
+
+func (lt *LoadTest) saveSessions() {
+	if lt.Config.SessionFile == "" {
+		return
+	}
+
+	if err := lt.sessions.save(lt.Config.SessionFile); err != nil {
+		lt.Log.Printf("failed to save sessions: %s\n", err.Error())
+	}
+}
+
+// Stops all users and waits for them to finish their current task, so that their
+// sessions are captured. Users that don't stop in time lose their session.
+func (lt *LoadTest) stopAllUsers(timeout time.Duration) {
+	lt.TargetUserNum = 0
+	lt.UserMapLock.Lock()
+	numUsers := len(lt.UserMap)
+	lt.UserMapLock.Unlock()
+	lt.stopUsers(numUsers)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		lt.UserMapLock.Lock()
+		numUsers = len(lt.UserMap)
+		lt.UserMapLock.Unlock()
+		if numUsers == 0 {
+			return
 		}
+		time.Sleep(time.Millisecond * 50)
 	}
+
+	lt.Log.Printf("%d users did not stop in time, their sessions are not saved\n", numUsers)
 }
 
 func (lt *LoadTest) Run(entryTask *Task) {
 	lt.Log.Println("Starting Load Testing Tool")
 
+	lt.loadSessions()
+	lt.loadBaseline()
+
+	if lt.Config.StatsDAddress != "" {
+		interval := lt.Config.StatsDInterval
+		if interval <= 0 {
+			interval = 10
+		}
+
+		e := NewStatsDExporter(lt, lt.Config.StatsDProtocol, lt.Config.StatsDAddress, lt.Config.StatsDPrefix,
+			time.Second*time.Duration(interval))
+		e.Tags = parseTags(lt.Config.StatsDTags)
+		lt.AddTaskRunHandler(e)
+		go e.Run()
+	}
+
+	if lt.Config.InfluxURL != "" {
+		interval := lt.Config.InfluxInterval
+		if interval <= 0 {
+			interval = 10
+		}
+
+		e, err := NewInfluxExporter(lt, lt.Config.InfluxURL, lt.Config.InfluxAggregate, time.Second*time.Duration(interval))
+		if err != nil {
+			lt.Log.Fatalf("failed to create influx exporter: %s\n", err.Error())
+		}
+		e.Token = lt.Config.InfluxToken
+		lt.AddTaskRunHandler(e)
+		go e.Run()
+	}
+
+	if lt.Config.SampleLogFile != "" {
+		format := lt.Config.SampleLogFormat
+		if format == "" {
+			format = SampleLogFormatJSONL
+		}
+		ratio := lt.Config.SampleLogRatio
+		if ratio == 0 {
+			ratio = 1
+		}
+
+		l, err := NewSampleLog(lt, lt.Config.SampleLogFile, format, ratio, int64(lt.Config.SampleLogMaxSize)*1024*1024)
+		if err != nil {
+			lt.Log.Fatalf("failed to create sample log: %s\n", err.Error())
+		}
+		lt.SampleLog = l
+		lt.AddTaskRunHandler(l)
+		go l.Run()
+	}
+
 	if lt.Config.SpawnOnStartup {
 		lt.TargetUserNum = lt.Config.NumUsers
 	}
 
+	lt.startStatsShards()
 	go lt.taskRunsJob()
 	go lt.runAPIJob()
-	go lt.cleanRPSJob()
+	go lt.throughputJob()
+	go lt.historyJob()
 	go lt.usersJob(entryTask)
+	if lt.Config.ReportDir != "" {
+		go lt.reportJob()
+	}
+
+	// Run until interrupted
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
 
-	// Run forever
-	c := make(chan struct{})
-	<-c
+	lt.Log.Println("Shutting down")
+	if lt.Config.SessionFile != "" {
+		lt.stopAllUsers(time.Second * time.Duration(lt.Config.RequestTimeout+1))
+	}
+	lt.stopRecording()
+	lt.flushHandlers()
+	lt.saveSessions()
+	lt.writeReports()
+
+	if lt.Baseline != nil {
+		if c, err := lt.CompareWithBaseline(lt.Baseline); err == nil {
+			lt.logComparison(c)
+		}
+	}
 }
 
 func NewLoadTest(config Config) *LoadTest {
+	if config.RunID == "" {
+		config.RunID = time.Now().Format("20060102T150405")
+	}
+
+	stats := NewStatistics()
+	if config.HistorySize > 0 {
+		stats.History = NewStatsHistory(config.HistorySize)
+	}
+	if config.RPSWindow > 0 {
+		stats.RPSWindow = config.RPSWindow
+	}
+
 	return &LoadTest{
 		Config:      config,
 		Status:      StatusStopped,
 		UserMap:     make(map[int64]User, config.NumUsers),
-		Stats:       NewStatistics(),
+		Stats:       stats,
 		TaskRunChan: make(chan *TaskRun, config.NumUsers),
+		statsShards: newStatsShards(config.StatsShards),
+		Feeders:     make(map[string]*Feeder),
+		Shared:      NewSharedStorage(),
+		sessions:    newSessionStore(),
 		Log:         log.New(config.LogOutput, config.LogPrefix, config.LogFlags),
 	}
 }
@@ -0,0 +1,47 @@
+package ltt
+
+import (
+	"testing"
+)
+
+func TestAddUserAssignsLowestFreeIDs(t *testing.T) {
+	lt := NewLoadTest(Config{})
+	f := NewFeeder("users", newTestFeederRows(10), FeederPolicyUnique)
+
+	// A first batch, of which user 1 is stopped
+	var next int64
+	for i := 0; i < 3; i++ {
+		next = lt.addUser(NewDefaultUser(nil), next) + 1
+	}
+	delete(lt.UserMap, 1)
+
+	// The target is raised, the new batch fills the gap first
+	ids := []int64{}
+	next = 0
+	for i := 0; i < 3; i++ {
+		next = lt.addUser(NewDefaultUser(nil), next) + 1
+		ids = append(ids, next-1)
+	}
+	if ids[0] != 1 || ids[1] != 3 || ids[2] != 4 {
+		t.Fatalf("IDs = %v, want [1 3 4]", ids)
+	}
+	if len(lt.UserMap) != 5 {
+		t.Fatalf("%d users, want 5", len(lt.UserMap))
+	}
+
+	// Running users get distinct unique rows
+	rows := map[interface{}]int64{}
+	for id, u := range lt.UserMap {
+		if u.ID() != id {
+			t.Fatalf("user %d is in UserMap as %d", u.ID(), id)
+		}
+		row, err := f.Next(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := rows[row["i"]]; ok {
+			t.Fatalf("users %d and %d have the same unique row", other, id)
+		}
+		rows[row["i"]] = id
+	}
+}
@@ -0,0 +1,146 @@
+package ltt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Upper bounds in seconds of the Prometheus latency histogram buckets
+var MetricsLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetricHeader(buf *bytes.Buffer, name string, typ string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatMetricFloat(f float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%f", f), "0"), ".")
+}
+
+// Writes the latency histogram of a task in the Prometheus format with
+// cumulative buckets, a value is counted in the first bucket its bucket fits in
+func writeLatencyHistogram(buf *bytes.Buffer, task string, h *Histogram) {
+	counts := make([]int64, len(MetricsLatencyBuckets))
+	h.ForEachBucket(func(us int64, count int64) {
+		secs := float64(us) / 1e6
+		for i, le := range MetricsLatencyBuckets {
+			if secs <= le {
+				counts[i] += count
+				break
+			}
+		}
+	})
+
+	var cumulative int64
+	for i, le := range MetricsLatencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(buf, "ltt_task_duration_seconds_bucket{task=\"%s\",le=\"%s\"} %d\n", task, formatMetricFloat(le), cumulative)
+	}
+	fmt.Fprintf(buf, "ltt_task_duration_seconds_bucket{task=\"%s\",le=\"+Inf\"} %d\n", task, h.Count())
+	fmt.Fprintf(buf, "ltt_task_duration_seconds_sum{task=\"%s\"} %s\n", task, formatMetricFloat(h.Mean()*float64(h.Count())/1e6))
+	fmt.Fprintf(buf, "ltt_task_duration_seconds_count{task=\"%s\"} %d\n", task, h.Count())
+}
+
+// Returns the metrics in the Prometheus text format. Labels are limited to the
+// task name, outcome, error class, status code and status, which are all bounded sets.
+func (lt *LoadTest) PrometheusMetrics() []byte {
+	buf := &bytes.Buffer{}
+
+	lt.Stats.Lock()
+	defer lt.Stats.Unlock()
+	lt.MergeStats()
+
+	writeMetricHeader(buf, "ltt_status", "gauge", "Current load test status, 1 for the active status.")
+	for st := StatusStopped; st <= StatusStopping; st++ {
+		v := 0
+		if st == lt.Status {
+			v = 1
+		}
+		fmt.Fprintf(buf, "ltt_status{status=\"%s\"} %d\n", st, v)
+	}
+
+	writeMetricHeader(buf, "ltt_users_running", "gauge", "Number of running users.")
+	fmt.Fprintf(buf, "ltt_users_running %d\n", lt.Stats.RunningUsers)
+	writeMetricHeader(buf, "ltt_users_target", "gauge", "Target number of users.")
+	fmt.Fprintf(buf, "ltt_users_target %d\n", lt.TargetUserNum)
+
+	progress := 1.0
+	if lt.TargetUserNum > 0 {
+		progress = float64(lt.Stats.RunningUsers) / float64(lt.TargetUserNum)
+	}
+	writeMetricHeader(buf, "ltt_spawn_progress_ratio", "gauge", "Running users relative to the target number of users.")
+	fmt.Fprintf(buf, "ltt_spawn_progress_ratio %s\n", formatMetricFloat(progress))
+
+	names := make([]string, 0, len(lt.Stats.Tasks))
+	for name := range lt.Stats.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeMetricHeader(buf, "ltt_task_runs_total", "counter", "Number of task runs by outcome.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		label := metricsLabelEscaper.Replace(name)
+		t.Lock()
+		fmt.Fprintf(buf, "ltt_task_runs_total{task=\"%s\",outcome=\"success\"} %d\n", label, t.NumSuccessful)
+		fmt.Fprintf(buf, "ltt_task_runs_total{task=\"%s\",outcome=\"failure\"} %d\n", label, t.NumFailed)
+		t.Unlock()
+	}
+
+	writeMetricHeader(buf, "ltt_task_errors_total", "counter", "Number of failed task runs by error class.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		label := metricsLabelEscaper.Replace(name)
+		t.Lock()
+		classes := make([]string, 0, len(t.ErrorClasses))
+		for class := range t.ErrorClasses {
+			classes = append(classes, string(class))
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(buf, "ltt_task_errors_total{task=\"%s\",class=\"%s\"} %d\n",
+				label, class, t.ErrorClasses[ErrorClass(class)].Count)
+		}
+		t.Unlock()
+	}
+
+	writeMetricHeader(buf, "ltt_task_http_responses_total", "counter", "Number of HTTP responses of the task by status code.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		label := metricsLabelEscaper.Replace(name)
+		t.Lock()
+		for _, code := range sortedStatusCodes(t.StatusCodes) {
+			fmt.Fprintf(buf, "ltt_task_http_responses_total{task=\"%s\",code=\"%d\"} %d\n", label, code, t.StatusCodes[code])
+		}
+		t.Unlock()
+	}
+
+	writeMetricHeader(buf, "ltt_task_bytes_sent_total", "counter", "Bytes sent by the requests of the task, including headers.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		t.Lock()
+		fmt.Fprintf(buf, "ltt_task_bytes_sent_total{task=\"%s\"} %d\n", metricsLabelEscaper.Replace(name), t.BytesSent)
+		t.Unlock()
+	}
+
+	writeMetricHeader(buf, "ltt_task_bytes_received_total", "counter", "Bytes received by the requests of the task, including headers.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		t.Lock()
+		fmt.Fprintf(buf, "ltt_task_bytes_received_total{task=\"%s\"} %d\n", metricsLabelEscaper.Replace(name), t.BytesReceived)
+		t.Unlock()
+	}
+
+	writeMetricHeader(buf, "ltt_task_duration_seconds", "histogram", "Task run duration in seconds.")
+	for _, name := range names {
+		t := lt.Stats.Tasks[name]
+		t.Lock()
+		writeLatencyHistogram(buf, metricsLabelEscaper.Replace(name), t.Histogram)
+		t.Unlock()
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,146 @@
+package ltt
+
+import (
+	"time"
+)
+
+// Phases that are derived from the load test status, custom stages can be set with LoadTest.SetPhase
+const (
+	PhaseRampUp   = "ramp-up"
+	PhaseSteady   = "steady"
+	PhaseRampDown = "ramp-down"
+)
+
+// Stats of the task runs of a single phase
+type PhaseStats struct {
+	Name string `json:"name"`
+	// Time of the first and latest task run in the phase
+	StartTime       time.Time             `json:"start_time"`
+	EndTime         time.Time             `json:"end_time"`
+	NumTotal        int64                 `json:"num_total"`
+	NumSuccessful   int64                 `json:"num_successful"`
+	NumFailed       int64                 `json:"num_failed"`
+	TotalDuration   int64                 `json:"total_duration"`
+	AverageDuration float32               `json:"average_duration"`
+	Tasks           map[string]*TaskStats `json:"tasks"`
+}
+
+// Records the run and returns the task stats of the phase to record it in
+func (ps *PhaseStats) record(name string, tr *TaskRun, now time.Time) *TaskStats {
+	if ps.NumTotal == 0 {
+		ps.StartTime = now
+	}
+	ps.EndTime = now
+	ps.NumTotal++
+	ps.TotalDuration += tr.Duration.Milliseconds()
+	if tr.Error != nil {
+		ps.NumFailed++
+	} else {
+		ps.NumSuccessful++
+	}
+
+	ts, ok := ps.Tasks[name]
+	if !ok {
+		ts = NewTaskStat(name)
+		ps.Tasks[name] = ts
+	}
+
+	return ts
+}
+
+// Merges partial stats of the phase
+func (ps *PhaseStats) Merge(other *PhaseStats) {
+	if other.NumTotal == 0 {
+		return
+	}
+
+	if ps.NumTotal == 0 || other.StartTime.Before(ps.StartTime) {
+		ps.StartTime = other.StartTime
+	}
+	if other.EndTime.After(ps.EndTime) {
+		ps.EndTime = other.EndTime
+	}
+	ps.NumTotal += other.NumTotal
+	ps.NumSuccessful += other.NumSuccessful
+	ps.NumFailed += other.NumFailed
+	ps.TotalDuration += other.TotalDuration
+
+	for name, ot := range other.Tasks {
+		if ot.TotalRuns == 0 {
+			continue
+		}
+		t, ok := ps.Tasks[name]
+		if !ok {
+			t = NewTaskStat(name)
+			t.Tags = ot.Tags
+			ps.Tasks[name] = t
+		}
+		t.Lock()
+		t.Merge(ot)
+		t.Unlock()
+	}
+}
+
+// Clears the stats so that partial stats can be reused, see statsShard
+func (ps *PhaseStats) reset() {
+	if ps.NumTotal == 0 {
+		return
+	}
+
+	ps.StartTime = time.Time{}
+	ps.EndTime = time.Time{}
+	ps.NumTotal = 0
+	ps.NumSuccessful = 0
+	ps.NumFailed = 0
+	ps.TotalDuration = 0
+	for _, t := range ps.Tasks {
+		t.reset()
+	}
+}
+
+func (ps *PhaseStats) Calculate() {
+	if ps.NumTotal > 0 {
+		ps.AverageDuration = float32(ps.TotalDuration) / float32(ps.NumTotal)
+	}
+
+	for _, t := range ps.Tasks {
+		t.Lock()
+		t.Calculate()
+		t.Unlock()
+	}
+}
+
+func NewPhaseStats(name string) *PhaseStats {
+	return &PhaseStats{
+		Name:  name,
+		Tasks: make(map[string]*TaskStats),
+	}
+}
+
+// Sets a custom phase that task runs are recorded in, overriding the phase derived
+// from the status. An empty name goes back to the derived phases.
+func (lt *LoadTest) SetPhase(name string) {
+	lt.phaseLock.Lock()
+	lt.phase = name
+	lt.phaseLock.Unlock()
+}
+
+// Returns the phase task runs are currently recorded in. Users being stopped,
+// to any lower target, is ramp-down and users being spawned is ramp-up.
+func (lt *LoadTest) currentPhase() string {
+	lt.phaseLock.RLock()
+	phase := lt.phase
+	rampingDown := lt.rampingDown
+	lt.phaseLock.RUnlock()
+	if phase != "" {
+		return phase
+	}
+
+	if rampingDown || lt.Status == StatusStopping {
+		return PhaseRampDown
+	} else if lt.Status == StatusSpawning {
+		return PhaseRampUp
+	}
+
+	return PhaseSteady
+}
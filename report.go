@@ -0,0 +1,428 @@
+package ltt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Names of the files written to Config.ReportDir
+const (
+	ReportStatsFile        = "ltt_stats.csv"
+	ReportFailuresFile     = "ltt_failures.csv"
+	ReportStatsHistoryFile = "ltt_stats_history.csv"
+	ReportPhasesFile       = "ltt_stats_phases.csv"
+	ReportRequestsFile     = "ltt_requests.csv"
+	ReportStatusCodesFile  = "ltt_status_codes.csv"
+	ReportJSONFile         = "ltt_report.json"
+	ReportHTMLFile         = "ltt_report.html"
+)
+
+// Name of the row with the totals of all tasks in the reports
+const AggregatedName = "Aggregated"
+
+// Returned for reports without stats, e.g. a JSON file that isn't a report
+var ErrNoStats = errors.New("report has no stats")
+
+var reportPercentiles = []int{50, 75, 85, 95, 99}
+
+// Full results of a run, written as the JSON report
+type Report struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Config      Config     `json:"config"`
+	Status      StatusType `json:"status"`
+	// Duration of the steady phase in seconds
+	Duration   float64          `json:"duration"`
+	Stats      *Statistics      `json:"stats"`
+	Aggregated *TaskStats       `json:"aggregated"`
+	History    []*StatsSnapshot `json:"history"`
+}
+
+// Returns the task stats sorted by name
+func (r *Report) SortedTasks() []*TaskStats {
+	tasks := make([]*TaskStats, 0, len(r.Stats.Tasks))
+	for _, t := range r.Stats.Tasks {
+		tasks = append(tasks, t)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+
+	return tasks
+}
+
+// Returns the request stats sorted by name
+func (r *Report) SortedRequests() []*RequestStats {
+	requests := make([]*RequestStats, 0, len(r.Stats.Requests))
+	for _, rs := range r.Stats.Requests {
+		requests = append(requests, rs)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Name < requests[j].Name
+	})
+
+	return requests
+}
+
+// Returns the phases in the order they started
+func (r *Report) SortedPhases() []*PhaseStats {
+	phases := make([]*PhaseStats, 0, len(r.Stats.Phases))
+	for _, p := range r.Stats.Phases {
+		phases = append(phases, p)
+	}
+
+	sort.Slice(phases, func(i, j int) bool {
+		return phases[i].StartTime.Before(phases[j].StartTime)
+	})
+
+	return phases
+}
+
+// Returns the task stats of the phase sorted by name
+func (p *PhaseStats) SortedTasks() []*TaskStats {
+	tasks := make([]*TaskStats, 0, len(p.Tasks))
+	for _, t := range p.Tasks {
+		tasks = append(tasks, t)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+
+	return tasks
+}
+
+// Creates a report of the current stats, the stats are deep copied so the
+// report can be used without holding the stats lock
+func (lt *LoadTest) NewReport() (*Report, error) {
+	stats, history, err := lt.copyStats()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		GeneratedAt: time.Now(),
+		Config:      lt.Config,
+		Status:      lt.Status,
+		Stats:       stats,
+		Aggregated:  NewTaskStat(AggregatedName),
+		History:     history,
+	}
+
+	end := r.GeneratedAt
+	if stats.EndTime.After(stats.StartTime) {
+		end = stats.EndTime
+	}
+	if !stats.StartTime.IsZero() {
+		r.Duration = end.Sub(stats.StartTime).Seconds()
+	}
+
+	for _, t := range stats.Tasks {
+		r.Aggregated.Merge(t)
+	}
+	r.Aggregated.Calculate()
+
+	return r, nil
+}
+
+// Returns a deep copy of the merged and calculated stats, and the history
+func (lt *LoadTest) copyStats() (*Statistics, []*StatsSnapshot, error) {
+	lt.Stats.Lock()
+	defer lt.Stats.Unlock()
+
+	lt.MergeStats()
+	lt.Stats.Calculate()
+	data, err := json.Marshal(lt.Stats)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := NewStatistics()
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, nil, err
+	}
+	// The throughput isn't part of the JSON
+	stats.copyThroughput(lt.Stats)
+
+	return stats, lt.Stats.History.Snapshots(), nil
+}
+
+func LoadReport(path string) (*Report, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	if r.Stats == nil {
+		return nil, fmt.Errorf("failed to load report %s: %w", path, ErrNoStats)
+	}
+
+	return r, nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// Returns the Apdex score, empty if the task isn't scored
+func formatApdex(t *TaskStats) string {
+	if t.ApdexThreshold <= 0 {
+		return ""
+	}
+
+	return formatFloat(t.Apdex)
+}
+
+// Returns a percentile corrected for coordinated omission, empty if the task isn't corrected
+func formatCorrectedPercentile(t *TaskStats, p int) string {
+	if t.CorrectedPercentiles == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(t.CorrectedPercentiles[p], 10)
+}
+
+func writeCSV(records [][]string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func taskStatsRecord(t *TaskStats) []string {
+	rps := float64(t.CurrentRPS)
+	fps := float64(t.CurrentFailuresPerSecond)
+
+	record := []string{
+		t.Name,
+		strconv.FormatInt(t.TotalRuns, 10),
+		strconv.FormatInt(t.NumFailed, 10),
+		strconv.FormatInt(t.Percentiles[50], 10),
+		formatFloat(float64(t.AverageDuration)),
+		formatFloat(t.MinDuration),
+		formatFloat(t.MaxDuration),
+		formatFloat(t.StdDevDuration),
+		formatFloat(rps),
+		formatFloat(fps),
+	}
+	for _, p := range reportPercentiles {
+		record = append(record, strconv.FormatInt(t.Percentiles[p], 10))
+	}
+	record = append(record, formatApdex(t))
+	for _, p := range reportPercentiles {
+		record = append(record, formatCorrectedPercentile(t, p))
+	}
+
+	return record
+}
+
+// Per task stats in the style of Locust's stats CSV, of all phases combined, durations in milliseconds
+func (r *Report) StatsCSV() ([]byte, error) {
+	header := []string{"Name", "Request Count", "Failure Count", "Median Response Time",
+		"Average Response Time", "Min Response Time", "Max Response Time", "StdDev Response Time",
+		"Requests/s", "Failures/s"}
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("%d%%", p))
+	}
+	header = append(header, "Apdex")
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("Corrected %d%%", p))
+	}
+
+	records := [][]string{header}
+	for _, t := range r.SortedTasks() {
+		records = append(records, taskStatsRecord(t))
+	}
+
+	agg := taskStatsRecord(r.Aggregated)
+	agg[8] = formatFloat(float64(r.Stats.CurrentRPS))
+	agg[9] = formatFloat(float64(r.Stats.CurrentFailuresPerSecond))
+	records = append(records, agg)
+
+	return writeCSV(records)
+}
+
+// Per task stats of each phase, with the totals of each phase
+func (r *Report) PhasesCSV() ([]byte, error) {
+	header := []string{"Phase", "Name", "Request Count", "Failure Count", "Median Response Time",
+		"Average Response Time", "Min Response Time", "Max Response Time", "StdDev Response Time",
+		"Requests/s", "Failures/s"}
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("%d%%", p))
+	}
+	header = append(header, "Apdex")
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("Corrected %d%%", p))
+	}
+
+	records := [][]string{header}
+	for _, p := range r.SortedPhases() {
+		agg := NewTaskStat(AggregatedName)
+		for _, t := range p.SortedTasks() {
+			records = append(records, append([]string{p.Name}, taskStatsRecord(t)...))
+			agg.Merge(t)
+			// The rates were calculated with the stats, the totals are their sums
+			agg.CurrentRPS += t.CurrentRPS
+			agg.CurrentFailuresPerSecond += t.CurrentFailuresPerSecond
+		}
+		agg.Calculate()
+		records = append(records, append([]string{p.Name}, taskStatsRecord(agg)...))
+	}
+
+	return writeCSV(records)
+}
+
+// Per request name byte counts, wire sizes include headers
+func (r *Report) RequestsCSV() ([]byte, error) {
+	records := [][]string{{"Name", "Request Count", "Failure Count", "Bytes Sent", "Bytes Received",
+		"Body Bytes Sent", "Body Bytes Received", "Average Bytes Received"}}
+	for _, rs := range r.SortedRequests() {
+		avg := 0.0
+		if rs.NumRequests > 0 {
+			avg = float64(rs.BytesReceived) / float64(rs.NumRequests)
+		}
+
+		records = append(records, []string{
+			rs.Name,
+			strconv.FormatInt(rs.NumRequests, 10),
+			strconv.FormatInt(rs.NumFailed, 10),
+			strconv.FormatInt(rs.BytesSent, 10),
+			strconv.FormatInt(rs.BytesReceived, 10),
+			strconv.FormatInt(rs.BodyBytesSent, 10),
+			strconv.FormatInt(rs.BodyBytesReceived, 10),
+			formatFloat(avg),
+		})
+	}
+
+	return writeCSV(records)
+}
+
+// Returns the status codes sorted
+func sortedStatusCodes(codes map[int]int64) []int {
+	sorted := make([]int, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Ints(sorted)
+
+	return sorted
+}
+
+// Counts by HTTP status code per task and per request name
+func (r *Report) StatusCodesCSV() ([]byte, error) {
+	records := [][]string{{"Type", "Name", "Status Code", "Count"}}
+	for _, t := range r.SortedTasks() {
+		for _, code := range sortedStatusCodes(t.StatusCodes) {
+			records = append(records, []string{"Task", t.Name, strconv.Itoa(code), strconv.FormatInt(t.StatusCodes[code], 10)})
+		}
+	}
+	for _, rs := range r.SortedRequests() {
+		for _, code := range sortedStatusCodes(rs.StatusCodes) {
+			records = append(records, []string{"Request", rs.Name, strconv.Itoa(code), strconv.FormatInt(rs.StatusCodes[code], 10)})
+		}
+	}
+
+	return writeCSV(records)
+}
+
+func (r *Report) FailuresCSV() ([]byte, error) {
+	records := [][]string{{"Name", "Error", "Occurrences"}}
+	for _, t := range r.SortedTasks() {
+		errs := make([]string, 0, len(t.Errors))
+		for e := range t.Errors {
+			errs = append(errs, e)
+		}
+		sort.Strings(errs)
+
+		for _, e := range errs {
+			records = append(records, []string{t.Name, e, strconv.FormatInt(t.Errors[e], 10)})
+		}
+	}
+
+	return writeCSV(records)
+}
+
+func (r *Report) StatsHistoryCSV() ([]byte, error) {
+	records := [][]string{{"Timestamp", "User Count", "Name", "Requests/s", "Failures/s", "50%", "95%", "99%"}}
+	for _, s := range r.History {
+		ts := strconv.FormatInt(s.Time.Unix(), 10)
+		users := strconv.Itoa(s.RunningUsers)
+
+		names := make([]string, 0, len(s.Tasks))
+		for name := range s.Tasks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			t := s.Tasks[name]
+			records = append(records, []string{ts, users, name, formatFloat(t.RPS), formatFloat(t.FailuresPerSecond),
+				formatFloat(t.P50), formatFloat(t.P95), formatFloat(t.P99)})
+		}
+		records = append(records, []string{ts, users, AggregatedName, formatFloat(s.RPS),
+			formatFloat(s.FailuresPerSecond), formatFloat(s.P50), formatFloat(s.P95), formatFloat(s.P99)})
+	}
+
+	return writeCSV(records)
+}
+
+// Writes the CSV and JSON reports to the directory
+func (r *Report) WriteFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		gen  func() ([]byte, error)
+	}{
+		{ReportStatsFile, r.StatsCSV},
+		{ReportFailuresFile, r.FailuresCSV},
+		{ReportStatsHistoryFile, r.StatsHistoryCSV},
+		{ReportPhasesFile, r.PhasesCSV},
+		{ReportRequestsFile, r.RequestsCSV},
+		{ReportStatusCodesFile, r.StatusCodesCSV},
+		{ReportJSONFile, func() ([]byte, error) { return json.MarshalIndent(r, "", "  ") }},
+		{ReportHTMLFile, r.HTML},
+	}
+
+	for _, f := range files {
+		data, err := f.gen()
+		if err != nil {
+			return fmt.Errorf("failed to generate %s: %w", f.name, err)
+		}
+
+		if err := writeFileAtomic(filepath.Join(dir, f.name), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes to a temporary file first to not leave a truncated file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
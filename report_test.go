@@ -0,0 +1,89 @@
+package ltt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewReportCopiesThroughput(t *testing.T) {
+	lt := NewLoadTest(Config{StatsShards: 1})
+	task := NewTask("task", nil, nil, TaskOptions{})
+	now := time.Now().Add(-time.Second)
+	lt.Stats.Lock()
+	for i := 0; i < 10; i++ {
+		lt.Stats.Record(newBenchTaskRun(task, 0, int64(i)), now)
+	}
+	lt.Stats.Unlock()
+
+	r, err := lt.NewReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := r.Stats.Tasks["task"]
+	if ts.Throughput == nil || r.Stats.Tags["read"].Throughput == nil || r.Stats.Requests["GET /bench"].Throughput == nil {
+		t.Fatal("the report stats have no throughput")
+	}
+	for _, p := range r.Stats.Phases {
+		if p.Tasks["task"].Throughput == nil {
+			t.Fatalf("the task stats of phase %s have no throughput", p.Name)
+		}
+	}
+
+	r.Stats.RPSWindow = 10
+	r.Stats.Calculate()
+	if ts.CurrentRPS != 1 {
+		t.Fatalf("CurrentRPS = %f, want 1", ts.CurrentRPS)
+	}
+
+	// The report stats are a copy
+	lt.Stats.Lock()
+	lt.Stats.Record(newBenchTaskRun(task, 0, 0), now)
+	lt.Stats.Unlock()
+	r.Stats.Calculate()
+	if ts.CurrentRPS != 1 {
+		t.Fatalf("CurrentRPS = %f after recording in the load test, want 1", ts.CurrentRPS)
+	}
+}
+
+func TestLoadedStatsCalculate(t *testing.T) {
+	lt := NewLoadTest(Config{StatsShards: 1})
+	task := NewTask("task", nil, nil, TaskOptions{})
+	now := time.Now().Add(-time.Second)
+	for i := 0; i < 100; i++ {
+		lt.Stats.Record(newBenchTaskRun(task, 0, int64(i)), now)
+	}
+
+	// Marshalled before calculating, so the percentiles are only in the histogram
+	data, err := json.Marshal(lt.Stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStatistics()
+	if err := json.Unmarshal(data, stats); err != nil {
+		t.Fatal(err)
+	}
+	stats.RPSWindow = 10
+	stats.Calculate()
+	stats.UpdateThroughput(time.Now(), true)
+
+	ts := stats.Tasks["task"]
+	h := lt.Stats.Tasks["task"].Histogram
+	for _, p := range []int{50, 95, 99} {
+		if us := h.ValueAtPercentile(float64(p)); us == 0 || ts.PercentilesUS[p] != us {
+			t.Fatalf("PercentilesUS[%d] = %d, want %d from the histogram", p, ts.PercentilesUS[p], us)
+		}
+	}
+	if ts.MaxDuration != 99 {
+		t.Fatalf("MaxDuration = %f, want 99", ts.MaxDuration)
+	}
+
+	// Unmarshalled stats have no throughput counters, so there are no current rates
+	if stats.CurrentRPS != 0 || stats.PeakRPS != 0 || ts.CurrentRPS != 0 || stats.Tags["read"].CurrentRPS != 0 {
+		t.Fatalf("current RPS = %f, %f, %f, want 0", stats.CurrentRPS, ts.CurrentRPS, stats.Tags["read"].CurrentRPS)
+	}
+	if stats.CurrentBytesReceivedPerSecond != 0 || stats.Requests["GET /bench"].CurrentBytesReceivedPerSecond != 0 {
+		t.Fatal("unmarshalled stats have current byte rates")
+	}
+}
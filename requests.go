@@ -0,0 +1,253 @@
+package ltt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Max number of request names with their own stats, further names are counted under "(other)"
+const MaxRequestNames = 1000
+
+type requestLogContextKeyType int
+
+var requestLogContextKey requestLogContextKeyType
+
+// Byte counts of requests, "wire" sizes include the request line, status line
+// and headers, and bodies as encoded on the connection. Body sizes are decoded.
+type ByteStats struct {
+	BytesSent         int64 `json:"bytes_sent"`
+	BytesReceived     int64 `json:"bytes_received"`
+	BodyBytesSent     int64 `json:"body_bytes_sent"`
+	BodyBytesReceived int64 `json:"body_bytes_received"`
+}
+
+func (bs *ByteStats) Add(other ByteStats) {
+	bs.BytesSent += other.BytesSent
+	bs.BytesReceived += other.BytesReceived
+	bs.BodyBytesSent += other.BodyBytesSent
+	bs.BodyBytesReceived += other.BodyBytesReceived
+}
+
+// A single request made by an HTTPClient during a task run
+type RequestRun struct {
+	// Name to aggregate the request by, "METHOD /path" by default
+	Name       string
+	Method     string
+	StatusCode int
+	StartTime  time.Time
+	Duration   time.Duration
+	Error      error
+	Timing     RequestTiming
+	ByteStats
+}
+
+// The requests of the task run a user is currently running. Tasks can make
+// requests from several goroutines, so it's locked.
+type requestLog struct {
+	sync.Mutex
+	// Requests are only logged between start and take, so that the log
+	// doesn't grow for users that don't collect it
+	active   bool
+	requests []*RequestRun
+}
+
+func newRequestLogContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestLogContextKey, &requestLog{})
+}
+
+func requestLogFromContext(ctx context.Context) *requestLog {
+	if rl, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		return rl
+	}
+
+	return nil
+}
+
+// Starts logging requests, dropping any logged before
+func (rl *requestLog) start() {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.active = true
+	rl.requests = nil
+}
+
+func (rl *requestLog) add(r *RequestRun) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	if rl.active {
+		rl.requests = append(rl.requests, r)
+	}
+}
+
+// Returns the logged requests and stops logging
+func (rl *requestLog) take() []*RequestRun {
+	rl.Lock()
+	defer rl.Unlock()
+
+	requests := rl.requests
+	rl.active = false
+	rl.requests = nil
+
+	return requests
+}
+
+// Starts collecting the requests HTTPClients make for the user of the context, for
+// custom Users that record their own task runs with LoadTest.RecordTaskRun. The
+// requests are returned by TakeRequests, they're not collected otherwise.
+func StartRequests(ctx context.Context) {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		rl.start()
+	}
+}
+
+// Returns the requests made since StartRequests, for TaskRun.Requests, and stops collecting them
+func TakeRequests(ctx context.Context) []*RequestRun {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		return rl.take()
+	}
+
+	return nil
+}
+
+// Stats of all requests with the same name
+type RequestStats struct {
+	sync.Mutex
+	Name        string `json:"name"`
+	NumRequests int64  `json:"num_requests"`
+	NumFailed   int64  `json:"num_failed"`
+	ByteStats
+	Connections ConnectionStats `json:"connections"`
+	// HTTP status code -> count, and status class (e.g. "2xx") -> count
+	StatusCodes   map[int]int64    `json:"status_codes"`
+	StatusClasses map[string]int64 `json:"status_classes"`
+	// Per second byte counts to calculate the current byte throughput
+	Throughput                    *RateCounter `json:"-"`
+	CurrentBytesSentPerSecond     float64      `json:"current_bytes_sent_per_second"`
+	CurrentBytesReceivedPerSecond float64      `json:"current_bytes_received_per_second"`
+}
+
+// Records a request, must be called with the lock held
+func (rs *RequestStats) Record(r *RequestRun, now time.Time) {
+	rs.NumRequests++
+	if r.Error != nil {
+		rs.NumFailed++
+	}
+	rs.ByteStats.Add(r.ByteStats)
+	rs.Connections.Record(&r.Timing)
+	recordStatusCode(rs.StatusCodes, rs.StatusClasses, r.StatusCode)
+	rs.Throughput.RecordBytes(now, r.BytesSent, r.BytesReceived)
+}
+
+// Merges partial stats, must be called with the lock held
+func (rs *RequestStats) Merge(other *RequestStats) {
+	rs.NumRequests += other.NumRequests
+	rs.NumFailed += other.NumFailed
+	rs.ByteStats.Add(other.ByteStats)
+	rs.Connections.Merge(&other.Connections)
+	mergeStatusCodes(rs.StatusCodes, rs.StatusClasses, other.StatusCodes, other.StatusClasses)
+	rs.Throughput.Merge(other.Throughput)
+}
+
+// Clears the stats so that partial stats can be reused, see statsShard
+func (rs *RequestStats) reset() {
+	if rs.NumRequests == 0 {
+		return
+	}
+
+	rs.NumRequests = 0
+	rs.NumFailed = 0
+	rs.ByteStats = ByteStats{}
+	rs.Connections = ConnectionStats{}
+	for code := range rs.StatusCodes {
+		delete(rs.StatusCodes, code)
+	}
+	for class := range rs.StatusClasses {
+		delete(rs.StatusClasses, class)
+	}
+	rs.Throughput.Reset()
+}
+
+func NewRequestStats(name string) *RequestStats {
+	return &RequestStats{
+		Name:          name,
+		Throughput:    NewRateCounter(),
+		StatusCodes:   make(map[int]int64),
+		StatusClasses: make(map[string]int64),
+	}
+}
+
+// Returns the summed byte counts of the requests of a task run
+func (tr *TaskRun) Bytes() ByteStats {
+	bs := ByteStats{}
+	for _, r := range tr.Requests {
+		bs.Add(r.ByteStats)
+	}
+
+	return bs
+}
+
+// Returns the class of a status code, e.g. "4xx" for 429
+func StatusClass(code int) string {
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// Counts a status code and its class, 0 means the request got no response
+func recordStatusCode(codes map[int]int64, classes map[string]int64, code int) {
+	if code <= 0 {
+		return
+	}
+
+	codes[code]++
+	classes[StatusClass(code)]++
+}
+
+func mergeStatusCodes(codes map[int]int64, classes map[string]int64, otherCodes map[int]int64, otherClasses map[string]int64) {
+	for code, c := range otherCodes {
+		codes[code] += c
+	}
+	for class, c := range otherClasses {
+		classes[class] += c
+	}
+}
+
+// Approximate size of a header block, each line is "Key: value\r\n" and
+// the block ends with an empty line
+func headerSize(h http.Header) int64 {
+	var n int64
+	for k, vs := range h {
+		for _, v := range vs {
+			n += int64(len(k) + len(v) + 4)
+		}
+	}
+
+	return n + 2
+}
+
+// Approximate wire size of the request line and headers of a request
+func requestHeaderSize(req *http.Request) int64 {
+	n := int64(len(fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.Host)))
+	return n + headerSize(req.Header)
+}
+
+// Approximate wire size of the status line and headers of a response
+func responseHeaderSize(resp *http.Response) int64 {
+	return int64(len(fmt.Sprintf("%s %s\r\n", resp.Proto, resp.Status))) + headerSize(resp.Header)
+}
+
+// Counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,50 @@
+package ltt
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestRequestLogConcurrentRequests(t *testing.T) {
+	ctx := newRequestLogContext(context.Background())
+	rl := requestLogFromContext(ctx)
+
+	StartRequests(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rl.add(&RequestRun{Name: "GET /"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(TakeRequests(ctx)); n != 1000 {
+		t.Fatalf("took %d requests, want 1000", n)
+	}
+}
+
+func TestRequestLogOnlyLogsWhenStarted(t *testing.T) {
+	ctx := newRequestLogContext(context.Background())
+	rl := requestLogFromContext(ctx)
+
+	rl.add(&RequestRun{Name: "GET /before"})
+	StartRequests(ctx)
+	rl.add(&RequestRun{Name: "GET /during"})
+	requests := TakeRequests(ctx)
+	rl.add(&RequestRun{Name: "GET /after"})
+
+	if len(requests) != 1 || requests[0].Name != "GET /during" {
+		t.Fatalf("took %v, want only GET /during", requests)
+	}
+	if len(rl.requests) != 0 {
+		t.Fatalf("%d requests logged after TakeRequests", len(rl.requests))
+	}
+	if requests := TakeRequests(context.Background()); requests != nil {
+		t.Fatalf("took %v without a request log", requests)
+	}
+}
@@ -2,9 +2,12 @@ package ltt
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func RunAPIServer(lt *LoadTest) error {
@@ -14,6 +17,7 @@ func RunAPIServer(lt *LoadTest) error {
 		}
 
 		lt.Stats.Lock()
+		lt.MergeStats()
 		lt.Stats.Calculate()
 		data, err := json.Marshal(lt)
 		lt.Stats.Unlock()
@@ -29,6 +33,168 @@ func RunAPIServer(lt *LoadTest) error {
 		writer.Write(data)
 	})
 
+	http.HandleFunc("/stats/history", func(writer http.ResponseWriter, request *http.Request) {
+		if lt.Config.Verbose {
+			lt.Log.Println("http: /stats/history request")
+		}
+
+		// Optional bucket size in seconds to downsample the history to
+		step, _ := strconv.Atoi(request.URL.Query().Get("step"))
+		// Optional unix timestamp to only return newer snapshots
+		since, _ := strconv.ParseInt(request.URL.Query().Get("since"), 10, 64)
+
+		lt.Stats.Lock()
+		snapshots := lt.Stats.History.Snapshots()
+		lt.Stats.Unlock()
+
+		if since > 0 {
+			filtered := []*StatsSnapshot{}
+			for _, s := range snapshots {
+				if s.Time.Unix() > since {
+					filtered = append(filtered, s)
+				}
+			}
+			snapshots = filtered
+		}
+
+		snapshots = DownsampleHistory(snapshots, time.Second*time.Duration(step))
+		data, err := json.Marshal(snapshots)
+		if err != nil {
+			lt.Log.Printf("error marshalling stats history: %s\n", err.Error())
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+	})
+
+	http.HandleFunc("/stats/tags", func(writer http.ResponseWriter, request *http.Request) {
+		if lt.Config.Verbose {
+			lt.Log.Println("http: /stats/tags request")
+		}
+
+		// Optional comma separated tags to filter by, all tags if empty
+		filter := map[string]bool{}
+		for _, tag := range strings.Split(request.URL.Query().Get("tag"), ",") {
+			if tag != "" {
+				filter[tag] = true
+			}
+		}
+
+		resp := struct {
+			// Tag -> stats of all tasks with the tag
+			Tags map[string]*TaskStats `json:"tags"`
+			// The tasks with any of the tags
+			Tasks map[string]*TaskStats `json:"tasks"`
+		}{map[string]*TaskStats{}, map[string]*TaskStats{}}
+
+		lt.Stats.Lock()
+		lt.MergeStats()
+		lt.Stats.Calculate()
+		for tag, t := range lt.Stats.Tags {
+			if len(filter) == 0 || filter[tag] {
+				resp.Tags[tag] = t
+			}
+		}
+		for name, t := range lt.Stats.Tasks {
+			for _, tag := range t.Tags {
+				if _, ok := resp.Tags[tag]; ok {
+					resp.Tasks[name] = t
+					break
+				}
+			}
+		}
+		data, err := json.Marshal(resp)
+		lt.Stats.Unlock()
+
+		if err != nil {
+			lt.Log.Printf("error marshalling tag stats: %s\n", err.Error())
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+	})
+
+	http.HandleFunc("/report.html", func(writer http.ResponseWriter, request *http.Request) {
+		if lt.Config.Verbose {
+			lt.Log.Println("http: /report.html request")
+		}
+
+		r, err := lt.NewReport()
+		var data []byte
+		if err == nil {
+			data, err = r.HTML()
+		}
+
+		if err != nil {
+			lt.Log.Printf("error creating html report: %s\n", err.Error())
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+	})
+
+	http.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		if lt.Config.Verbose {
+			lt.Log.Println("http: /metrics request")
+		}
+
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(lt.PrometheusMetrics())
+	})
+
+	// Compares to the configured baseline, or to a baseline report posted as the body
+	http.HandleFunc("/compare", func(writer http.ResponseWriter, request *http.Request) {
+		if lt.Config.Verbose {
+			lt.Log.Println("http: /compare request")
+		}
+
+		baseline := lt.Baseline
+		if request.Method == http.MethodPost {
+			baseline = &Report{}
+			if err := json.NewDecoder(request.Body).Decode(baseline); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				writer.Write([]byte(fmt.Sprintf("invalid baseline report: %s", err.Error())))
+				return
+			}
+		}
+
+		if baseline == nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("no baseline report"))
+			return
+		}
+
+		c, err := lt.CompareWithBaseline(baseline)
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(c)
+		}
+
+		if errors.Is(err, ErrNoHistogram) || errors.Is(err, ErrNoStats) {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte(fmt.Sprintf("invalid baseline report: %s", err.Error())))
+			return
+		} else if err != nil {
+			lt.Log.Printf("error comparing to baseline: %s\n", err.Error())
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+	})
+
 	http.HandleFunc("/set-num-users", func(writer http.ResponseWriter, request *http.Request) {
 		numUsers, _ := strconv.Atoi(request.URL.Query().Get("num-users"))
 		lt.Log.Printf("http: /set-num-users request, num-users: %d\n", numUsers)
@@ -36,9 +202,34 @@ func RunAPIServer(lt *LoadTest) error {
 		writer.WriteHeader(http.StatusOK)
 	})
 
+	http.HandleFunc("/set-phase", func(writer http.ResponseWriter, request *http.Request) {
+		phase := request.URL.Query().Get("phase")
+		lt.Log.Printf("http: /set-phase request, phase: %s\n", phase)
+		lt.SetPhase(phase)
+		writer.WriteHeader(http.StatusOK)
+	})
+
+	http.HandleFunc("/sample-log/rotate", func(writer http.ResponseWriter, request *http.Request) {
+		lt.Log.Println("http: /sample-log/rotate request")
+		if lt.SampleLog == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := lt.SampleLog.Rotate(); err != nil {
+			lt.Log.Printf("error rotating sample log: %s\n", err.Error())
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	})
+
 	http.HandleFunc("/reset", func(writer http.ResponseWriter, request *http.Request) {
 		lt.Log.Println("http: /reset request")
 		lt.Stats.Lock()
+		// Runs recorded before the reset are merged to be discarded
+		lt.MergeStats()
 		lt.Stats.Reset()
 		lt.Stats.Unlock()
 		writer.WriteHeader(http.StatusOK)
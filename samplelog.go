@@ -0,0 +1,410 @@
+package ltt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SampleLogFormatJSONL  = "jsonl"
+	SampleLogFormatBinary = "binary"
+	// Max number of samples buffered before samples are dropped
+	MaxSampleLogBuffer = 64 * 1024
+	// Max length of a task name or error class in the binary format, longer ones
+	// are read as a corrupt file
+	MaxSampleLogString = 64 * 1024
+)
+
+// Binary sample log format, all integers are varints:
+//
+//	header: "LTTS" version(1 byte)
+//	string: 0 id len bytes, defines a task name or error class used by later samples
+//	sample: 1 timestamp(unix ns) user_id task_id duration(µs) error_class_id(0 if none) status_code
+var sampleLogMagic = []byte("LTTS\x01")
+
+const (
+	sampleRecordString byte = iota
+	sampleRecordSample
+)
+
+var ErrSampleLogFormat = errors.New("invalid sample log format")
+
+// A single task run in the sample log
+type Sample struct {
+	// Start time in unix nanoseconds
+	Timestamp  int64      `json:"timestamp"`
+	UserID     int64      `json:"user_id"`
+	Task       string     `json:"task"`
+	Duration   float64    `json:"duration_ms"`
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
+	// Status code of the run's last HTTP request, 0 if it made none
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+func newSample(tr *TaskRun) *Sample {
+	s := &Sample{
+		Timestamp: tr.StartTime.UnixNano(),
+		UserID:    tr.UserID,
+		Task:      tr.Task.FullName(),
+		Duration:  float64(tr.Duration.Microseconds()) / 1000,
+	}
+
+	if tr.Error != nil {
+		s.ErrorClass, _ = ClassifyError(tr.Error)
+	}
+	if len(tr.Requests) > 0 {
+		s.StatusCode = tr.Requests[len(tr.Requests)-1].StatusCode
+	}
+
+	return s
+}
+
+// Streams a sample of the task runs to a JSONL or binary file. Samples are
+// buffered up to MaxSampleLogBuffer and dropped when the writer falls behind.
+type SampleLog struct {
+	sync.Mutex
+	lt     *LoadTest
+	Path   string
+	Format string
+	// Ratio of the task runs that are logged, between 0 and 1
+	Ratio float64
+	// Size in bytes at which the file is rotated, 0 disables rotation
+	MaxSize int64
+
+	samples chan *Sample
+	dropped int64
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	// String table of the binary format
+	strings map[string]uint64
+	buf     []byte
+}
+
+func (l *SampleLog) HandleTaskRun(tr *TaskRun) {
+	if l.Ratio < 1 && rand.Float64() >= l.Ratio {
+		return
+	}
+
+	select {
+	case l.samples <- newSample(tr):
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+// Returns an unused file name for a rotated file, the rotation time before the extension
+func rotatedPath(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	base := fmt.Sprintf("%s.%s", strings.TrimSuffix(path, ext), t.Format("20060102T150405.000"))
+	rotated := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			return rotated
+		}
+		rotated = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// Opens the file, an existing non-empty file is rotated first so that each file is self-contained
+func (l *SampleLog) open() error {
+	if fi, err := os.Stat(l.Path); err == nil && fi.Size() > 0 {
+		if err := os.Rename(l.Path, rotatedPath(l.Path, time.Now())); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.file = f
+	l.w = bufio.NewWriterSize(f, 64*1024)
+	l.size = 0
+	l.strings = make(map[string]uint64)
+
+	if l.Format == SampleLogFormatBinary {
+		return l.writeBytes(sampleLogMagic)
+	}
+
+	return nil
+}
+
+func (l *SampleLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.w.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+
+	return err
+}
+
+// Closes the current file and starts a new one
+func (l *SampleLog) Rotate() error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.rotate()
+}
+
+func (l *SampleLog) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *SampleLog) writeBytes(b []byte) error {
+	n, err := l.w.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func (l *SampleLog) appendString(s string) uint64 {
+	if len(s) > MaxSampleLogString {
+		s = s[:MaxSampleLogString]
+	}
+
+	if id, ok := l.strings[s]; ok {
+		return id
+	}
+
+	id := uint64(len(l.strings) + 1)
+	l.strings[s] = id
+	l.buf = append(l.buf, sampleRecordString)
+	l.buf = appendUvarint(l.buf, id)
+	l.buf = appendUvarint(l.buf, uint64(len(s)))
+	l.buf = append(l.buf, s...)
+
+	return id
+}
+
+func (l *SampleLog) encodeBinary(s *Sample) []byte {
+	l.buf = l.buf[:0]
+	taskID := l.appendString(s.Task)
+	var classID uint64
+	if s.ErrorClass != "" {
+		classID = l.appendString(string(s.ErrorClass))
+	}
+
+	l.buf = append(l.buf, sampleRecordSample)
+	l.buf = appendVarint(l.buf, s.Timestamp)
+	l.buf = appendVarint(l.buf, s.UserID)
+	l.buf = appendUvarint(l.buf, taskID)
+	l.buf = appendUvarint(l.buf, uint64(math.Round(s.Duration*1000)))
+	l.buf = appendUvarint(l.buf, classID)
+	l.buf = appendUvarint(l.buf, uint64(s.StatusCode))
+
+	return l.buf
+}
+
+// Writes a sample, must be called with the lock held
+func (l *SampleLog) write(s *Sample) error {
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	if l.MaxSize > 0 && l.size >= l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if l.Format == SampleLogFormatBinary {
+		return l.writeBytes(l.encodeBinary(s))
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return l.writeBytes(append(data, '\n'))
+}
+
+// Writes the buffered samples to the file
+func (l *SampleLog) Flush() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if dropped := atomic.SwapInt64(&l.dropped, 0); dropped > 0 {
+		l.lt.Log.Printf("SampleLog: dropped %d samples, the buffer was full\n", dropped)
+	}
+
+	for {
+		select {
+		case s := <-l.samples:
+			if err := l.write(s); err != nil {
+				return err
+			}
+		default:
+			if l.file == nil {
+				return nil
+			}
+			return l.w.Flush()
+		}
+	}
+}
+
+// Writes samples as they come in and flushes every second, forever
+func (l *SampleLog) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case s := <-l.samples:
+			l.Lock()
+			err = l.write(s)
+			l.Unlock()
+		case <-ticker.C:
+			err = l.Flush()
+		}
+
+		if err != nil {
+			l.lt.Log.Printf("SampleLog: failed to write to %s: %s\n", l.Path, err.Error())
+		}
+	}
+}
+
+func NewSampleLog(lt *LoadTest, path string, format string, ratio float64, maxSize int64) (*SampleLog, error) {
+	switch format {
+	case SampleLogFormatJSONL, SampleLogFormatBinary:
+	default:
+		return nil, fmt.Errorf("unsupported sample log format: %s", format)
+	}
+
+	if ratio <= 0 || ratio > 1 {
+		return nil, fmt.Errorf("sample log ratio must be between 0 and 1: %g", ratio)
+	}
+
+	return &SampleLog{
+		lt:      lt,
+		Path:    path,
+		Format:  format,
+		Ratio:   ratio,
+		MaxSize: maxSize,
+		samples: make(chan *Sample, MaxSampleLogBuffer),
+	}, nil
+}
+
+// Reads a JSONL or binary sample log, calling fn for each sample
+func ReadSamples(r io.Reader, fn func(*Sample) error) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(sampleLogMagic))
+	if err == io.EOF && len(head) == 0 {
+		return nil
+	}
+
+	if !bytes.Equal(head, sampleLogMagic) {
+		dec := json.NewDecoder(br)
+		for {
+			s := &Sample{}
+			if err := dec.Decode(s); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	br.Discard(len(sampleLogMagic))
+	strs := map[uint64]string{}
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		return v
+	}
+	varint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(br)
+		return v
+	}
+
+	for {
+		kind, rerr := br.ReadByte()
+		if rerr == io.EOF {
+			return nil
+		} else if rerr != nil {
+			return rerr
+		}
+
+		err = nil
+		switch kind {
+		case sampleRecordString:
+			id := uvarint()
+			n := uvarint()
+			if err != nil {
+				break
+			}
+			if n > MaxSampleLogString {
+				return ErrSampleLogFormat
+			}
+			b := make([]byte, n)
+			if _, err = io.ReadFull(br, b); err == nil {
+				strs[id] = string(b)
+			}
+		case sampleRecordSample:
+			s := &Sample{}
+			s.Timestamp = varint()
+			s.UserID = varint()
+			s.Task = strs[uvarint()]
+			s.Duration = float64(uvarint()) / 1000
+			s.ErrorClass = ErrorClass(strs[uvarint()])
+			s.StatusCode = int(uvarint())
+			if err == nil {
+				err = fn(s)
+			}
+		default:
+			return ErrSampleLogFormat
+		}
+
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+	}
+}
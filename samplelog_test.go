@@ -0,0 +1,154 @@
+package ltt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Writes runs with and without errors to a sample log and returns the samples of the runs
+func writeTestSamples(t *testing.T, format string) (string, []*Sample) {
+	lt := NewLoadTest(Config{LogOutput: ioutil.Discard})
+	path := filepath.Join(t.TempDir(), "samples."+format)
+	l, err := NewSampleLog(lt, path, format, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := NewEntryTask("entry", nil, TaskOptions{})
+	view := entry.AddSubTask("view", nil, TaskOptions{})
+	start := time.Unix(1700000000, 123456789)
+	samples := []*Sample{}
+	for i := 0; i < 50; i++ {
+		tr := &TaskRun{
+			Task:      view,
+			StartTime: start.Add(time.Duration(i) * time.Millisecond),
+			Duration:  time.Duration(i*1234) * time.Microsecond,
+			UserID:    int64(i % 7),
+		}
+		if i%2 == 0 {
+			tr.Task = entry
+		}
+		if i%5 == 0 {
+			tr.Error = errBench
+		}
+		if i%3 == 0 {
+			tr.Requests = []*RequestRun{{StatusCode: 200}, {StatusCode: 500 + i}}
+		}
+		l.HandleTaskRun(tr)
+		samples = append(samples, newSample(tr))
+	}
+
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, samples
+}
+
+func readTestSamples(t *testing.T, path string) []*Sample {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples := []*Sample{}
+	err = ReadSamples(f, func(s *Sample) error {
+		samples = append(samples, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return samples
+}
+
+func TestReadSamplesRoundTrip(t *testing.T) {
+	for _, format := range []string{SampleLogFormatJSONL, SampleLogFormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			path, want := writeTestSamples(t, format)
+			got := readTestSamples(t, path)
+
+			if len(got) != len(want) {
+				t.Fatalf("read %d samples, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Fatalf("sample %d = %+v, want %+v", i, got[i], want[i])
+				}
+			}
+			if got[5].ErrorClass == "" || got[3].StatusCode != 503 || got[1].Task != "entry / view" {
+				t.Fatalf("unexpected samples: %+v, %+v, %+v", got[5], got[3], got[1])
+			}
+		})
+	}
+}
+
+func TestReadSamplesEmpty(t *testing.T) {
+	err := ReadSamples(bytes.NewReader(nil), func(s *Sample) error {
+		t.Fatal("sample read from an empty file")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSamplesStopsOnCallbackError(t *testing.T) {
+	errStop := errors.New("stop")
+	for _, format := range []string{SampleLogFormatJSONL, SampleLogFormatBinary} {
+		path, _ := writeTestSamples(t, format)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		err = ReadSamples(bytes.NewReader(data), func(s *Sample) error {
+			n++
+			return errStop
+		})
+		if err != errStop || n != 1 {
+			t.Fatalf("%s: err = %v after %d samples, want errStop after 1", format, err, n)
+		}
+	}
+}
+
+func TestReadSamplesCorruptBinary(t *testing.T) {
+	path, _ := writeTestSamples(t, SampleLogFormatBinary)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns a binary log of the header and the bytes
+	binaryLog := func(b ...byte) []byte {
+		return append(append([]byte{}, sampleLogMagic...), b...)
+	}
+	for name, c := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"truncated": {data[:len(data)-1], io.ErrUnexpectedEOF},
+		// A string of 2^62 bytes
+		"string length":   {binaryLog(sampleRecordString, 1, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40), ErrSampleLogFormat},
+		"string too long": {append(appendUvarint(binaryLog(sampleRecordString, 1), MaxSampleLogString+1), 'a'), ErrSampleLogFormat},
+		"record type":     {binaryLog(9), ErrSampleLogFormat},
+	} {
+		err := ReadSamples(bytes.NewReader(c.data), func(s *Sample) error { return nil })
+		if err != c.err {
+			t.Fatalf("%s: err = %v, want %v", name, err, c.err)
+		}
+	}
+}
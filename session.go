@@ -0,0 +1,253 @@
+package ltt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type sessionContextKeyType int
+
+var sessionContextKey sessionContextKeyType
+
+// A typed Storage value, so that e.g. an int is not restored as a float64
+type sessionValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func newSessionValue(v interface{}) (*sessionValue, error) {
+	var typ string
+	switch v.(type) {
+	case int:
+		typ = "int"
+	case int64:
+		typ = "int64"
+	case float64:
+		typ = "float64"
+	case string:
+		typ = "string"
+	case bool:
+		typ = "bool"
+	default:
+		typ = "json"
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionValue{Type: typ, Value: data}, nil
+}
+
+func (sv *sessionValue) decode() (interface{}, error) {
+	var err error
+	switch sv.Type {
+	case "int":
+		var v int
+		err = json.Unmarshal(sv.Value, &v)
+		return v, err
+	case "int64":
+		var v int64
+		err = json.Unmarshal(sv.Value, &v)
+		return v, err
+	case "float64":
+		var v float64
+		err = json.Unmarshal(sv.Value, &v)
+		return v, err
+	case "string":
+		var v string
+		err = json.Unmarshal(sv.Value, &v)
+		return v, err
+	case "bool":
+		var v bool
+		err = json.Unmarshal(sv.Value, &v)
+		return v, err
+	}
+
+	var v interface{}
+	err = json.Unmarshal(sv.Value, &v)
+	return v, err
+}
+
+type clientSession struct {
+	Headers http.Header    `json:"headers"`
+	Cookies []*http.Cookie `json:"cookies"`
+}
+
+// The persisted state of a single user, see Config.SessionFile
+type Session struct {
+	SavedAt time.Time                 `json:"saved_at"`
+	Storage map[string]*sessionValue  `json:"storage"`
+	Clients map[string]*clientSession `json:"clients"`
+
+	// If the session was restored from a previous run
+	restored bool
+	// The HTTPClients created by the user in this run, saved on stop
+	clients []*HTTPClient
+}
+
+func (s *Session) Restored() bool {
+	return s.restored
+}
+
+// Applies the restored headers and cookies to a new client with the same base uri
+func (s *Session) restoreClient(c *HTTPClient) {
+	cs, ok := s.Clients[c.baseURI]
+	if !s.restored || !ok {
+		return
+	}
+
+	for k, v := range cs.Headers {
+		c.Headers[k] = v
+	}
+
+	if u, err := url.Parse(c.baseURI); err == nil {
+		c.std.Jar.SetCookies(u, cs.Cookies)
+	}
+}
+
+// Copies the restored Storage values into the user's storage
+func (s *Session) restoreStorage(storage *Storage) error {
+	for k, sv := range s.Storage {
+		v, err := sv.decode()
+		if err != nil {
+			return fmt.Errorf("failed to restore storage key %s: %w", k, err)
+		}
+		storage.Set(k, v)
+	}
+
+	return nil
+}
+
+// Snapshots the user's current storage and clients to be persisted
+func (s *Session) capture(storage *Storage) *Session {
+	snap := &Session{
+		SavedAt: time.Now(),
+		Storage: make(map[string]*sessionValue),
+		Clients: make(map[string]*clientSession),
+	}
+
+	for k, v := range storage.data {
+		sv, err := newSessionValue(v)
+		if err != nil {
+			// Skip values that can not be persisted
+			continue
+		}
+		snap.Storage[k] = sv
+	}
+
+	for _, c := range s.clients {
+		cs := &clientSession{Headers: c.Headers.Clone()}
+		if u, err := url.Parse(c.baseURI); err == nil {
+			cs.Cookies = c.std.Jar.Cookies(u)
+		}
+		snap.Clients[c.baseURI] = cs
+	}
+
+	return snap
+}
+
+func newSession() *Session {
+	return &Session{
+		Storage: make(map[string]*sessionValue),
+		Clients: make(map[string]*clientSession),
+	}
+}
+
+func NewSessionContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey, s)
+}
+
+func SessionFromContext(ctx context.Context) *Session {
+	if s, ok := ctx.Value(sessionContextKey).(*Session); ok {
+		return s
+	}
+
+	return nil
+}
+
+// Reports if the user's session was restored from a previous run, the entry
+// task can use this to skip authenticating again
+func SessionRestored(ctx context.Context) bool {
+	if s := SessionFromContext(ctx); s != nil {
+		return s.Restored()
+	}
+
+	return false
+}
+
+// User ID -> session, persisted to Config.SessionFile
+type sessionStore struct {
+	sync.Mutex
+	sessions map[int64]*Session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[int64]*Session)}
+}
+
+// Takes the restored session of a user, so that it is only restored once
+func (ss *sessionStore) take(id int64) *Session {
+	ss.Lock()
+	defer ss.Unlock()
+
+	s, ok := ss.sessions[id]
+	if !ok {
+		return newSession()
+	}
+
+	delete(ss.sessions, id)
+	s.restored = true
+	return s
+}
+
+func (ss *sessionStore) put(id int64, s *Session) {
+	ss.Lock()
+	ss.sessions[id] = s
+	ss.Unlock()
+}
+
+// Loads the sessions from the file, ignoring sessions older than maxAge if it's non-zero
+func (ss *sessionStore) load(path string, maxAge time.Duration) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sessions := make(map[int64]*Session)
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return err
+	}
+
+	ss.Lock()
+	defer ss.Unlock()
+	for id, s := range sessions {
+		if maxAge > 0 && time.Since(s.SavedAt) > maxAge {
+			continue
+		}
+		ss.sessions[id] = s
+	}
+
+	return nil
+}
+
+func (ss *sessionStore) save(path string) error {
+	ss.Lock()
+	data, err := json.Marshal(ss.sessions)
+	ss.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, 0600)
+}
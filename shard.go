@@ -0,0 +1,142 @@
+package ltt
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Number of task runs a stats shard buffers before users block on recording
+	StatsShardBufferSize = 4096
+	// Number of stats shards if Config.StatsShards isn't set, see it for their memory use
+	DefaultStatsShards = 4
+)
+
+// Records the task runs of a subset of the users in partial stats, so that
+// recording doesn't contend on the Stats lock. The partial stats are merged
+// into LoadTest.Stats by MergeStats. Each shard has two partial stats that
+// are swapped on each merge and reset in place, so merging doesn't allocate.
+type statsShard struct {
+	sync.Mutex
+	runs  chan *TaskRun
+	stats *Statistics
+	// Reset stats that are recorded in after the next take
+	spare *Statistics
+}
+
+func newStatsShard() *statsShard {
+	return &statsShard{
+		runs:  make(chan *TaskRun, StatsShardBufferSize),
+		stats: newPartialStatistics(),
+		spare: newPartialStatistics(),
+	}
+}
+
+// Partial stats only keep counts, no history or throughput window
+func newPartialStatistics() *Statistics {
+	return &Statistics{
+		Tasks:         make(map[string]*TaskStats),
+		Tags:          make(map[string]*TaskStats),
+		Phases:        make(map[string]*PhaseStats),
+		Requests:      make(map[string]*RequestStats),
+		Throughput:    NewRateCounter(),
+		interval:      newIntervalStats(),
+		taskIntervals: make(map[string]*intervalStats),
+	}
+}
+
+// Returns the partial stats and continues recording in the spare stats. The returned
+// stats become the spare, they must be reset before the next take.
+func (s *statsShard) take() *Statistics {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	s.stats, s.spare = s.spare, stats
+	return stats
+}
+
+func (s *statsShard) run(lt *LoadTest) {
+	for tr := range s.runs {
+		lt.handleTaskRun(s, tr)
+	}
+}
+
+func (lt *LoadTest) handleTaskRun(s *statsShard, tr *TaskRun) {
+	s.Lock()
+	s.stats.Record(tr, tr.recordTime)
+	s.Unlock()
+
+	for _, h := range lt.TaskRunHandlers {
+		h.HandleTaskRun(tr)
+	}
+}
+
+// Records a task run in the shard of its user, the run is in Stats after the next MergeStats.
+// Runs recorded after the shards have been closed on shutdown are dropped.
+func (lt *LoadTest) RecordTaskRun(tr *TaskRun) {
+	// Stats are collected in every phase, but not once stopped. The phase and time
+	// are set here, as the shard may handle the run after they have changed.
+	if lt.Status == StatusStopped {
+		return
+	}
+	tr.Phase = lt.currentPhase()
+	tr.recordTime = time.Now()
+
+	lt.shardsLock.RLock()
+	defer lt.shardsLock.RUnlock()
+	if lt.shardsClosed {
+		return
+	}
+
+	lt.statsShards[tr.UserID%int64(len(lt.statsShards))].runs <- tr
+}
+
+func (lt *LoadTest) startStatsShards() {
+	for _, s := range lt.statsShards {
+		lt.shardsDone.Add(1)
+		go func(s *statsShard) {
+			defer lt.shardsDone.Done()
+			s.run(lt)
+		}(s)
+	}
+}
+
+// Stops recording task runs and waits for the shards to handle the runs they
+// have buffered, the runs are in Stats after the next MergeStats
+func (lt *LoadTest) closeStatsShards() {
+	lt.shardsLock.Lock()
+	if !lt.shardsClosed {
+		lt.shardsClosed = true
+		for _, s := range lt.statsShards {
+			close(s.runs)
+		}
+	}
+	lt.shardsLock.Unlock()
+
+	lt.shardsDone.Wait()
+}
+
+// Merges the partial stats of the shards into Stats, must be called with the
+// Stats lock held, which also keeps merges from overlapping. It's called every
+// second, and before the stats are read.
+func (lt *LoadTest) MergeStats() {
+	for _, s := range lt.statsShards {
+		stats := s.take()
+		lt.Stats.Merge(stats)
+		stats.resetPartial()
+	}
+}
+
+func newStatsShards(n int) []*statsShard {
+	if n <= 0 {
+		n = DefaultStatsShards
+	}
+
+	shards := make([]*statsShard, n)
+	for i := range shards {
+		shards[i] = newStatsShard()
+	}
+
+	return shards
+}
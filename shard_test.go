@@ -0,0 +1,188 @@
+package ltt
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errBench = errors.New("bench error")
+
+func newBenchTaskRun(task *Task, userID int64, i int64) *TaskRun {
+	tr := &TaskRun{
+		Task:      task,
+		StartTime: time.Now(),
+		Duration:  time.Duration(i%1000) * time.Millisecond,
+		UserID:    userID,
+		Tags:      []string{"read"},
+		Requests: []*RequestRun{{
+			Name:       "GET /bench",
+			Method:     "GET",
+			StatusCode: 200,
+			ByteStats:  ByteStats{BytesSent: 100, BytesReceived: 1000},
+		}},
+	}
+	if i%100 == 0 {
+		tr.Error = errBench
+	}
+
+	return tr
+}
+
+func newBenchTasks(n int) []*Task {
+	tasks := make([]*Task, n)
+	for i := range tasks {
+		tasks[i] = NewTask(fmt.Sprintf("bench %d", i), nil, nil, TaskOptions{})
+	}
+
+	return tasks
+}
+
+// Records runs in partial stats, the cost of a shard recording a run
+func BenchmarkStatisticsRecord(b *testing.B) {
+	stats := newPartialStatistics()
+	task := NewTask("bench", nil, nil, TaskOptions{})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		stats.Record(newBenchTaskRun(task, 0, int64(i)), time.Now())
+	}
+}
+
+// Records runs from parallel users through the shards, until all runs are merged into Stats
+func BenchmarkRecordTaskRun(b *testing.B) {
+	counts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		counts = append(counts, n)
+	}
+
+	for _, shards := range counts {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			lt := NewLoadTest(Config{StatsShards: shards})
+			lt.Status = StatusRunning
+			lt.startStatsShards()
+			defer lt.closeStatsShards()
+			task := NewTask("bench", nil, nil, TaskOptions{})
+			var users int64
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				userID := atomic.AddInt64(&users, 1)
+				var i int64
+				for pb.Next() {
+					lt.RecordTaskRun(newBenchTaskRun(task, userID, i))
+					i++
+				}
+			})
+
+			for {
+				lt.Stats.Lock()
+				lt.MergeStats()
+				n := lt.Stats.NumTotal
+				lt.Stats.Unlock()
+				if n >= int64(b.N) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+// Records a run of each task in each shard and merges the shards under the
+// Stats lock, as the throughput job does every second
+func BenchmarkMergeStats(b *testing.B) {
+	for _, n := range []int{10, 100} {
+		b.Run(fmt.Sprintf("tasks=%d", n), func(b *testing.B) {
+			lt := NewLoadTest(Config{StatsShards: runtime.NumCPU()})
+			tasks := newBenchTasks(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for _, s := range lt.statsShards {
+					s.Lock()
+					for j, t := range tasks {
+						s.stats.Record(newBenchTaskRun(t, 0, int64(j)), time.Now())
+					}
+					s.Unlock()
+				}
+
+				lt.Stats.Lock()
+				lt.MergeStats()
+				lt.Stats.Unlock()
+			}
+		})
+	}
+}
+
+func TestCloseStatsShardsRecordsBufferedRuns(t *testing.T) {
+	lt := NewLoadTest(Config{StatsShards: 4})
+	lt.Status = StatusRunning
+	task := NewTask("task", nil, nil, TaskOptions{})
+
+	// Buffered before the shards are started, as if they had fallen behind
+	for i := 0; i < 1000; i++ {
+		lt.RecordTaskRun(newBenchTaskRun(task, int64(i), int64(i)))
+	}
+	lt.startStatsShards()
+	lt.closeStatsShards()
+	// Dropped once closed
+	lt.RecordTaskRun(newBenchTaskRun(task, 0, 0))
+
+	lt.Stats.Lock()
+	lt.MergeStats()
+	lt.Stats.Unlock()
+
+	if n := lt.Stats.NumTotal; n != 1000 {
+		t.Fatalf("NumTotal = %d, want 1000", n)
+	}
+	if n := lt.Stats.Tasks["task"].TotalRuns; n != 1000 {
+		t.Fatalf("task TotalRuns = %d, want 1000", n)
+	}
+}
+
+func TestRecordTaskRunSetsPhase(t *testing.T) {
+	lt := NewLoadTest(Config{StatsShards: 2})
+	task := NewTask("task", nil, nil, TaskOptions{})
+	record := func(status StatusType, phase string) *TaskRun {
+		lt.Status = status
+		lt.SetPhase(phase)
+		tr := newBenchTaskRun(task, 1, 1)
+		lt.RecordTaskRun(tr)
+		return tr
+	}
+
+	// The shards aren't started until the status has changed, as if they had fallen behind
+	rampUp := record(StatusSpawning, "")
+	steady := record(StatusRunning, "")
+	custom := record(StatusRunning, "checkout")
+	rampDown := record(StatusStopping, "")
+	record(StatusStopped, "")
+	lt.startStatsShards()
+	lt.closeStatsShards()
+
+	for tr, want := range map[*TaskRun]string{rampUp: PhaseRampUp, steady: PhaseSteady, custom: "checkout", rampDown: PhaseRampDown} {
+		if tr.Phase != want {
+			t.Fatalf("Phase = %q, want %q", tr.Phase, want)
+		}
+	}
+
+	lt.Stats.Lock()
+	lt.MergeStats()
+	lt.Stats.Unlock()
+
+	if n := lt.Stats.NumTotal; n != 4 {
+		t.Fatalf("NumTotal = %d, want the 4 runs recorded before stopping", n)
+	}
+	for _, name := range []string{PhaseRampUp, PhaseSteady, "checkout", PhaseRampDown} {
+		if p, ok := lt.Stats.Phases[name]; !ok || p.NumTotal != 1 {
+			t.Fatalf("phase %s doesn't have the run recorded in it: %+v", name, p)
+		}
+	}
+}
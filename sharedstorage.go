@@ -0,0 +1,216 @@
+package ltt
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("queue full")
+
+// Storage shared between all users of a load test, safe for concurrent use
+type SharedStorage struct {
+	sync.Mutex
+	data   map[string]interface{}
+	lists  map[string][]interface{}
+	queues map[string]*SharedQueue
+}
+
+func (s *SharedStorage) GetInt(key string) (int, error) {
+	if v, ok := s.Get(key).(int); ok {
+		return v, nil
+	}
+
+	return 0, ErrInvalidStorageValue
+}
+
+func (s *SharedStorage) GetInt64(key string) (int64, error) {
+	if v, ok := s.Get(key).(int64); ok {
+		return v, nil
+	}
+
+	return 0, ErrInvalidStorageValue
+}
+
+func (s *SharedStorage) GetString(key string) (string, error) {
+	if v, ok := s.Get(key).(string); ok {
+		return v, nil
+	}
+
+	return "", ErrInvalidStorageValue
+}
+
+func (s *SharedStorage) Get(key string) interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.data[key]
+}
+
+func (s *SharedStorage) Set(key string, value interface{}) {
+	s.Lock()
+	s.data[key] = value
+	s.Unlock()
+}
+
+func (s *SharedStorage) Delete(key string) {
+	s.Lock()
+	delete(s.data, key)
+	s.Unlock()
+}
+
+// Atomically adds delta to the int64 counter at key and returns the new value.
+// A missing key starts at 0.
+func (s *SharedStorage) Incr(key string, delta int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var v int64
+	if cur, ok := s.data[key]; ok {
+		if v, ok = cur.(int64); !ok {
+			return 0, ErrInvalidStorageValue
+		}
+	}
+
+	v += delta
+	s.data[key] = v
+	return v, nil
+}
+
+// Sets key to new if its current value equals old, a nil old matches a missing key.
+// The values must be comparable.
+func (s *SharedStorage) CompareAndSwap(key string, old interface{}, new interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.data[key] != old {
+		return false
+	}
+
+	s.data[key] = new
+	return true
+}
+
+// Appends a value to the named list, dropping the oldest values when
+// the list grows past max. A max of 0 means unbounded.
+func (s *SharedStorage) Append(key string, value interface{}, max int) {
+	s.Lock()
+	defer s.Unlock()
+
+	l := append(s.lists[key], value)
+	if max > 0 && len(l) > max {
+		l = l[len(l)-max:]
+	}
+	s.lists[key] = l
+}
+
+// Returns a copy of the named list
+func (s *SharedStorage) List(key string) []interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	l := make([]interface{}, len(s.lists[key]))
+	copy(l, s.lists[key])
+	return l
+}
+
+// Returns a random value from the named list, false if it is empty
+func (s *SharedStorage) RandomListItem(key string) (interface{}, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	l := s.lists[key]
+	if len(l) == 0 {
+		return nil, false
+	}
+
+	return l[rand.Intn(len(l))], true
+}
+
+// Returns the named queue, creating it with the given capacity if it does not exist
+func (s *SharedStorage) Queue(name string, capacity int) *SharedQueue {
+	s.Lock()
+	defer s.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok {
+		q = NewSharedQueue(capacity)
+		s.queues[name] = q
+	}
+
+	return q
+}
+
+func NewSharedStorage() *SharedStorage {
+	return &SharedStorage{
+		data:   make(map[string]interface{}),
+		lists:  make(map[string][]interface{}),
+		queues: make(map[string]*SharedQueue),
+	}
+}
+
+func SharedStorageFromContext(ctx context.Context) *SharedStorage {
+	if lt := FromContext(ctx); lt != nil {
+		return lt.Shared
+	}
+
+	return nil
+}
+
+// Bounded FIFO queue to pass values between users, e.g. from producer to consumer user types
+type SharedQueue struct {
+	ch chan interface{}
+}
+
+// Adds a value without blocking, returns ErrQueueFull if the queue is at capacity
+func (q *SharedQueue) Push(value interface{}) error {
+	select {
+	case q.ch <- value:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Adds a value, waiting for room until the context is done
+func (q *SharedQueue) PushWait(ctx context.Context, value interface{}) error {
+	select {
+	case q.ch <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Removes and returns the oldest value without blocking, false if the queue is empty
+func (q *SharedQueue) Pop() (interface{}, bool) {
+	select {
+	case v := <-q.ch:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// Removes and returns the oldest value, waiting for one until the context is done
+func (q *SharedQueue) PopWait(ctx context.Context) (interface{}, error) {
+	select {
+	case v := <-q.ch:
+		return v, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *SharedQueue) Len() int {
+	return len(q.ch)
+}
+
+func (q *SharedQueue) Cap() int {
+	return cap(q.ch)
+}
+
+func NewSharedQueue(capacity int) *SharedQueue {
+	return &SharedQueue{ch: make(chan interface{}, capacity)}
+}
@@ -1,74 +1,326 @@
 package ltt
 
 import (
-	"sort"
+	"errors"
+	"fmt"
+	"regexp"
 	"sync"
 	"time"
 )
 
+// Max number of distinct panic messages to keep a stack trace sample for
+const MaxPanicStacks = 20
+
+var (
+	hexRegexp    = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	numberRegexp = regexp.MustCompile(`[0-9]+`)
+)
+
+// Replaces addresses and numbers in an error message so that messages
+// that only differ in those are counted as the same error
+func normalizeMessage(msg string) string {
+	msg = hexRegexp.ReplaceAllString(msg, "<addr>")
+	return numberRegexp.ReplaceAllString(msg, "<n>")
+}
+
 type TaskStats struct {
 	sync.Mutex
-	Name            string           `json:"name"`
-	TotalRuns       int64            `json:"total_runs"`
-	NumSuccessful   int64            `json:"num_successful"`
-	NumFailed       int64            `json:"num_failed"`
-	TotalDuration   int64            `json:"total_duration"`
-	Metrics         map[int64]int64  `json:"-"`
-	Percentiles     map[int]int64    `json:"percentiles"`
-	AverageDuration float32          `json:"average_duration"`
-	Errors          map[string]int64 `json:"errors"`
+	Name string `json:"name"`
+	// Tags of the task, see TaskOptions.Tags and Config.UserLabels
+	Tags          []string `json:"tags,omitempty"`
+	TotalRuns     int64    `json:"total_runs"`
+	NumSuccessful int64    `json:"num_successful"`
+	NumFailed     int64    `json:"num_failed"`
+	TotalDuration int64    `json:"total_duration"`
+	// Per second counts to calculate the current throughput
+	Throughput               *RateCounter `json:"-"`
+	CurrentRPS               float32      `json:"current_rps"`
+	CurrentFailuresPerSecond float32      `json:"current_failures_per_second"`
+	// Bytes of the requests made during the runs
+	ByteStats
+	CurrentBytesSentPerSecond     float64 `json:"current_bytes_sent_per_second"`
+	CurrentBytesReceivedPerSecond float64 `json:"current_bytes_received_per_second"`
+	// Latencies of all runs in microseconds
+	Histogram *Histogram `json:"histogram"`
+	// Percentile -> duration in milliseconds
+	Percentiles map[int]int64 `json:"percentiles"`
+	// Percentile -> duration in microseconds
+	PercentilesUS map[int]int64 `json:"percentiles_us"`
+	// Latencies corrected for coordinated omission, only if runs had an expected interval
+	CorrectedHistogram *Histogram `json:"corrected_histogram,omitempty"`
+	// Percentile -> corrected duration in milliseconds and microseconds
+	CorrectedPercentiles   map[int]int64 `json:"corrected_percentiles,omitempty"`
+	CorrectedPercentilesUS map[int]int64 `json:"corrected_percentiles_us,omitempty"`
+	// Apdex threshold in milliseconds of the latest scored run, 0 if no run had a threshold
+	ApdexThreshold float64 `json:"apdex_threshold"`
+	// Runs within the threshold, within 4x the threshold, and slower or failed
+	ApdexSatisfied  int64 `json:"apdex_satisfied"`
+	ApdexTolerating int64 `json:"apdex_tolerating"`
+	ApdexFrustrated int64 `json:"apdex_frustrated"`
+	// Apdex score between 0 and 1
+	Apdex float64 `json:"apdex"`
+	// Durations in milliseconds
+	AverageDuration float32 `json:"average_duration"`
+	MinDuration     float64 `json:"min_duration"`
+	MaxDuration     float64 `json:"max_duration"`
+	StdDevDuration  float64 `json:"stddev_duration"`
+	// "class: normalized message" -> count, at most MaxErrorKeys keys including "(other)"
+	Errors       map[string]int64                `json:"errors"`
+	ErrorClasses map[ErrorClass]*ErrorClassStats `json:"error_classes"`
+	// HTTP status code -> count, and status class (e.g. "2xx") -> count, of the requests made during the runs
+	StatusCodes   map[int]int64    `json:"status_codes"`
+	StatusClasses map[string]int64 `json:"status_classes"`
+	// Normalized panic message -> a sampled stack trace of the first occurrence
+	PanicStacks map[string]string `json:"panic_stacks"`
+	// Slowest and latest failed runs with their trace IDs, see Config.TraceContext
+	SlowestSamples []*TraceSample `json:"slowest_samples"`
+	FailedSamples  []*TraceSample `json:"failed_samples"`
+}
+
+// Records a task run, must be called with the lock held or by the only user of the stats
+func (ts *TaskStats) Record(tr *TaskRun, now time.Time) {
+	ts.Throughput.Record(now, tr.Error != nil)
+	ts.Histogram.RecordDuration(tr.Duration)
+	if tr.ExpectedInterval > 0 {
+		if ts.CorrectedHistogram == nil {
+			ts.CorrectedHistogram = NewHistogram()
+		}
+		ts.CorrectedHistogram.RecordCorrectedDuration(tr.Duration, tr.ExpectedInterval)
+	}
+	if tr.TraceID != "" {
+		ts.SlowestSamples = addSlowestSample(ts.SlowestSamples, newTraceSample(tr))
+	}
+	ts.TotalRuns++
+	ts.TotalDuration += tr.Duration.Milliseconds()
+	bytes := tr.Bytes()
+	ts.ByteStats.Add(bytes)
+	ts.Throughput.RecordBytes(now, bytes.BytesSent, bytes.BytesReceived)
+	for _, r := range tr.Requests {
+		recordStatusCode(ts.StatusCodes, ts.StatusClasses, r.StatusCode)
+	}
+	ts.recordApdex(tr)
+	if tr.Error != nil {
+		ts.NumFailed++
+		ts.AddError(tr.Error)
+		if tr.TraceID != "" {
+			ts.FailedSamples = addLatestSample(ts.FailedSamples, newTraceSample(tr))
+		}
+	} else {
+		ts.NumSuccessful++
+	}
+}
+
+// Counts the run as satisfied, tolerating or frustrated if it has an Apdex threshold
+func (ts *TaskStats) recordApdex(tr *TaskRun) {
+	t := tr.ApdexThreshold
+	if t <= 0 {
+		return
+	}
+
+	ts.ApdexThreshold = float64(t.Microseconds()) / 1000
+	switch {
+	case tr.Error != nil || tr.Duration > 4*t:
+		ts.ApdexFrustrated++
+	case tr.Duration > t:
+		ts.ApdexTolerating++
+	default:
+		ts.ApdexSatisfied++
+	}
+}
+
+// Returns the key to count an error under. Once MaxErrorKeys is reached errors are
+// counted under otherErrorMessage, which has a key reserved for it.
+func (ts *TaskStats) errorKey(key string) string {
+	if _, ok := ts.Errors[key]; ok {
+		return key
+	}
+
+	n := len(ts.Errors)
+	if _, ok := ts.Errors[otherErrorMessage]; ok {
+		n--
+	}
+	if n >= MaxErrorKeys-1 {
+		return otherErrorMessage
+	}
+
+	return key
+}
+
+// Classifies and counts a failed task run's error
+func (ts *TaskStats) AddError(err error) {
+	class, msg := ClassifyError(err)
+
+	ts.Errors[ts.errorKey(fmt.Sprintf("%s: %s", class, msg))]++
+
+	ecs, ok := ts.ErrorClasses[class]
+	if !ok {
+		ecs = &ErrorClassStats{}
+		ts.ErrorClasses[class] = ecs
+	}
+	ecs.Count++
+
+	var httpErr *HTTPError
+	var panicErr *TaskPanicError
+	if errors.As(err, &httpErr) {
+		ecs.addSample(string(httpErr.Body))
+	} else {
+		ecs.addSample(err.Error())
+	}
+
+	if errors.As(err, &panicErr) {
+		if _, ok := ts.PanicStacks[msg]; !ok && len(ts.PanicStacks) < MaxPanicStacks {
+			ts.PanicStacks[msg] = string(panicErr.Stack)
+		}
+	}
+}
+
+// Adds the results of other to ts, e.g. from another run or process
+func (ts *TaskStats) Merge(other *TaskStats) {
+	ts.TotalRuns += other.TotalRuns
+	ts.NumSuccessful += other.NumSuccessful
+	ts.NumFailed += other.NumFailed
+	ts.TotalDuration += other.TotalDuration
+	ts.ByteStats.Add(other.ByteStats)
+	mergeStatusCodes(ts.StatusCodes, ts.StatusClasses, other.StatusCodes, other.StatusClasses)
+	ts.Histogram.Merge(other.Histogram)
+	if other.CorrectedHistogram != nil && other.CorrectedHistogram.Count() > 0 {
+		if ts.CorrectedHistogram == nil {
+			ts.CorrectedHistogram = NewHistogram()
+		}
+		ts.CorrectedHistogram.Merge(other.CorrectedHistogram)
+	}
+	// Unmarshalled stats have no throughput
+	if ts.Throughput != nil && other.Throughput != nil {
+		ts.Throughput.Merge(other.Throughput)
+	}
+	if other.ApdexThreshold > 0 {
+		ts.ApdexThreshold = other.ApdexThreshold
+	}
+	ts.ApdexSatisfied += other.ApdexSatisfied
+	ts.ApdexTolerating += other.ApdexTolerating
+	ts.ApdexFrustrated += other.ApdexFrustrated
+
+	for key, c := range other.Errors {
+		ts.Errors[ts.errorKey(key)] += c
+	}
+
+	for class, oecs := range other.ErrorClasses {
+		ecs, ok := ts.ErrorClasses[class]
+		if !ok {
+			ecs = &ErrorClassStats{}
+			ts.ErrorClasses[class] = ecs
+		}
+		ecs.Count += oecs.Count
+		for _, sample := range oecs.Samples {
+			ecs.addSample(sample)
+		}
+	}
+
+	for _, s := range other.SlowestSamples {
+		ts.SlowestSamples = addSlowestSample(ts.SlowestSamples, s)
+	}
+	for _, s := range other.FailedSamples {
+		ts.FailedSamples = addLatestSample(ts.FailedSamples, s)
+	}
+
+	for msg, stack := range other.PanicStacks {
+		if _, ok := ts.PanicStacks[msg]; !ok && len(ts.PanicStacks) < MaxPanicStacks {
+			ts.PanicStacks[msg] = stack
+		}
+	}
+}
+
+// Clears the stats so that partial stats can be reused, see statsShard
+func (ts *TaskStats) reset() {
+	if ts.TotalRuns == 0 {
+		return
+	}
+
+	ts.TotalRuns = 0
+	ts.NumSuccessful = 0
+	ts.NumFailed = 0
+	ts.TotalDuration = 0
+	ts.Throughput.Reset()
+	ts.ByteStats = ByteStats{}
+	ts.Histogram.Reset()
+	if ts.CorrectedHistogram != nil {
+		ts.CorrectedHistogram.Reset()
+	}
+	ts.ApdexThreshold = 0
+	ts.ApdexSatisfied = 0
+	ts.ApdexTolerating = 0
+	ts.ApdexFrustrated = 0
+	for key := range ts.Errors {
+		delete(ts.Errors, key)
+	}
+	for class := range ts.ErrorClasses {
+		delete(ts.ErrorClasses, class)
+	}
+	for code := range ts.StatusCodes {
+		delete(ts.StatusCodes, code)
+	}
+	for class := range ts.StatusClasses {
+		delete(ts.StatusClasses, class)
+	}
+	for msg := range ts.PanicStacks {
+		delete(ts.PanicStacks, msg)
+	}
+	ts.SlowestSamples = nil
+	ts.FailedSamples = nil
 }
 
 func (ts *TaskStats) Calculate() {
 	const MinRunsToCalculate = 10
 	percentiles := []float64{0.5, 0.75, 0.85, 0.95, 0.99}
 
+	if scored := ts.ApdexSatisfied + ts.ApdexTolerating + ts.ApdexFrustrated; scored > 0 {
+		ts.Apdex = (float64(ts.ApdexSatisfied) + float64(ts.ApdexTolerating)/2) / float64(scored)
+	}
+
 	if ts.TotalRuns < MinRunsToCalculate {
 		// set to zero to make output more consistent
 		for _, p := range percentiles {
 			ts.Percentiles[int(p*100)] = 0
+			ts.PercentilesUS[int(p*100)] = 0
 		}
 
 		return
 	}
 
-	type flatTaskStats struct {
-		Duration int64
-		Count    int64
-	}
-
-	flatMetrics := make([]flatTaskStats, len(ts.Metrics))
-	for d, c := range ts.Metrics {
-		flatMetrics = append(flatMetrics, flatTaskStats{d, c})
-	}
-
-	sort.Slice(flatMetrics, func(i, j int) bool {
-		return flatMetrics[i].Duration < flatMetrics[j].Duration
-	})
-
 	for _, p := range percentiles {
-		idx := int64(float64(ts.TotalRuns) * p)
-
-		var i int64
-		for _, fm := range flatMetrics {
-			i += fm.Count
+		us := ts.Histogram.ValueAtPercentile(p * 100)
+		ts.PercentilesUS[int(p*100)] = us
+		ts.Percentiles[int(p*100)] = us / 1000
+	}
 
-			if i >= idx {
-				ts.Percentiles[int(p*100)] = fm.Duration
-				break
-			}
+	if ts.CorrectedHistogram != nil {
+		ts.CorrectedPercentiles = make(map[int]int64, len(percentiles))
+		ts.CorrectedPercentilesUS = make(map[int]int64, len(percentiles))
+		for _, p := range percentiles {
+			us := ts.CorrectedHistogram.ValueAtPercentile(p * 100)
+			ts.CorrectedPercentilesUS[int(p*100)] = us
+			ts.CorrectedPercentiles[int(p*100)] = us / 1000
 		}
 	}
 
-	ts.AverageDuration = float32(ts.TotalDuration) / float32(ts.TotalRuns)
+	ts.AverageDuration = float32(ts.Histogram.Mean() / 1000)
+	ts.MinDuration = float64(ts.Histogram.Min()) / 1000
+	ts.MaxDuration = float64(ts.Histogram.Max()) / 1000
+	ts.StdDevDuration = ts.Histogram.StdDev() / 1000
 }
 
 func NewTaskStat(name string) *TaskStats {
 	return &TaskStats{
-		Name:        name,
-		Metrics:     make(map[int64]int64),
-		Percentiles: make(map[int]int64),
-		Errors:      make(map[string]int64),
+		Name:          name,
+		Throughput:    NewRateCounter(),
+		Histogram:     NewHistogram(),
+		Percentiles:   make(map[int]int64),
+		PercentilesUS: make(map[int]int64),
+		Errors:        make(map[string]int64),
+		ErrorClasses:  make(map[ErrorClass]*ErrorClassStats),
+		StatusCodes:   make(map[int]int64),
+		StatusClasses: make(map[string]int64),
+		PanicStacks:   make(map[string]string),
 	}
 }
 
@@ -81,14 +333,42 @@ type Statistics struct {
 	NumSuccessful int64     `json:"num_successful"`
 	NumFailed     int64     `json:"num_failed"`
 	TotalDuration int64     `json:"total_duration"`
-	// unix-timestamp -> count map to calculate a current RPS value
-	RPSMap map[int64]int64 `json:"-"`
+	// Per second counts to calculate the current throughput
+	Throughput *RateCounter `json:"-"`
+	// Sliding window in seconds for the current throughput
+	RPSWindow int `json:"rps_window"`
+
+	// All phases combined, so the totals include ramp-up and ramp-down, see
+	// Phases for the steady phase alone
+	Tasks map[string]*TaskStats `json:"tasks"`
+	// Tag -> stats of all tasks with the tag
+	Tags map[string]*TaskStats `json:"tags"`
+	// Phase name -> stats of the phase
+	Phases map[string]*PhaseStats `json:"phases"`
+	// Request name -> stats of the HTTPClient requests, see HTTPClient.RequestNamed
+	Requests map[string]*RequestStats `json:"requests"`
+	// Bytes of all requests
+	ByteStats
+	// Timings and connection reuse of all requests
+	Connections                   ConnectionStats `json:"connections"`
+	CurrentRPS                    float32         `json:"current_rps"`
+	CurrentFailuresPerSecond      float32         `json:"current_failures_per_second"`
+	CurrentBytesSentPerSecond     float64         `json:"current_bytes_sent_per_second"`
+	CurrentBytesReceivedPerSecond float64         `json:"current_bytes_received_per_second"`
+	// Peak and mean RPS over the steady, running phase
+	PeakRPS         float32 `json:"peak_rps"`
+	MeanRPS         float32 `json:"mean_rps"`
+	AverageDuration float32 `json:"average_duration"`
 
-	Tasks           map[string]*TaskStats `json:"tasks"`
-	CurrentRPS      float32               `json:"current_rps"`
-	AverageDuration float32               `json:"average_duration"`
+	// Per interval snapshots, served by the /stats/history endpoint
+	History *StatsHistory `json:"-"`
+	// Results since the last snapshot, overall and per task
+	interval      *intervalStats
+	taskIntervals map[string]*intervalStats
+	lastSnapshot  time.Time
 }
 
+// Default sliding window in seconds for the current throughput
 const RPSTimeWindow = 10
 
 func (ts *Statistics) Reset() {
@@ -97,53 +377,350 @@ func (ts *Statistics) Reset() {
 	ts.NumSuccessful = 0
 	ts.NumFailed = 0
 	ts.TotalDuration = 0
-	ts.RPSMap = map[int64]int64{}
+	ts.Throughput.Reset()
 	ts.Tasks = map[string]*TaskStats{}
+	ts.Tags = map[string]*TaskStats{}
+	ts.Phases = map[string]*PhaseStats{}
+	ts.Requests = map[string]*RequestStats{}
+	ts.ByteStats = ByteStats{}
+	ts.Connections = ConnectionStats{}
 	ts.CurrentRPS = 0
+	ts.CurrentFailuresPerSecond = 0
+	ts.PeakRPS = 0
+	ts.MeanRPS = 0
 	ts.AverageDuration = 0
+	ts.History.Reset()
+	ts.interval.Reset()
+	ts.taskIntervals = map[string]*intervalStats{}
+	ts.lastSnapshot = time.Now()
+}
+
+// Clears partial stats for reuse, keeping the task, tag, phase and request stats
+// so that their histograms and counters aren't allocated again
+func (ts *Statistics) resetPartial() {
+	ts.NumTotal = 0
+	ts.NumSuccessful = 0
+	ts.NumFailed = 0
+	ts.TotalDuration = 0
+	ts.Throughput.Reset()
+	ts.ByteStats = ByteStats{}
+	ts.Connections = ConnectionStats{}
+	ts.interval.Reset()
+	for _, is := range ts.taskIntervals {
+		is.Reset()
+	}
+	for _, t := range ts.Tasks {
+		t.reset()
+	}
+	for _, t := range ts.Tags {
+		t.reset()
+	}
+	for _, p := range ts.Phases {
+		p.reset()
+	}
+	for _, rs := range ts.Requests {
+		rs.reset()
+	}
+}
+
+// Records a task run, must be called with the lock held. Runs are recorded in the
+// partial stats of a shard, which are merged into LoadTest.Stats, see MergeStats.
+func (ts *Statistics) Record(tr *TaskRun, now time.Time) {
+	name := tr.Task.FullName()
+	bytes := tr.Bytes()
+
+	ts.Throughput.Record(now, tr.Error != nil)
+	ts.Throughput.RecordBytes(now, bytes.BytesSent, bytes.BytesReceived)
+	ts.ByteStats.Add(bytes)
+	ts.NumTotal++
+	ts.TotalDuration += tr.Duration.Milliseconds()
+	if tr.Error != nil {
+		ts.NumFailed++
+	} else {
+		ts.NumSuccessful++
+	}
+	ts.recordInterval(name, tr)
+
+	ts.taskStats(name, tr.Tags).Record(tr, now)
+	for _, tag := range tr.Tags {
+		ts.tagStats(tag).Record(tr, now)
+	}
+	ts.phaseStats(tr.Phase).record(name, tr, now).Record(tr, now)
+
+	for _, r := range tr.Requests {
+		ts.Connections.Record(&r.Timing)
+		ts.requestStats(r.Name).Record(r, now)
+	}
+}
+
+// Merges partial stats, must be called with the lock held
+func (ts *Statistics) Merge(other *Statistics) {
+	ts.NumTotal += other.NumTotal
+	ts.NumSuccessful += other.NumSuccessful
+	ts.NumFailed += other.NumFailed
+	ts.TotalDuration += other.TotalDuration
+	ts.Throughput.Merge(other.Throughput)
+	ts.ByteStats.Add(other.ByteStats)
+	ts.Connections.Merge(&other.Connections)
+
+	// Reused partial stats keep the entries of earlier merges, those without results are skipped
+	ts.interval.Merge(other.interval)
+	for name, ois := range other.taskIntervals {
+		if ois.NumTotal == 0 {
+			continue
+		}
+		is, ok := ts.taskIntervals[name]
+		if !ok {
+			is = newIntervalStats()
+			ts.taskIntervals[name] = is
+		}
+		is.Merge(ois)
+	}
+
+	for name, ot := range other.Tasks {
+		if ot.TotalRuns == 0 {
+			continue
+		}
+		t := ts.taskStats(name, ot.Tags)
+		t.Lock()
+		t.Merge(ot)
+		t.Unlock()
+	}
+
+	for tag, ot := range other.Tags {
+		if ot.TotalRuns == 0 {
+			continue
+		}
+		t := ts.tagStats(tag)
+		t.Lock()
+		t.Merge(ot)
+		t.Unlock()
+	}
+
+	for name, op := range other.Phases {
+		if op.NumTotal == 0 {
+			continue
+		}
+		ts.phaseStats(name).Merge(op)
+	}
+
+	for name, ors := range other.Requests {
+		if ors.NumRequests == 0 {
+			continue
+		}
+		rs := ts.requestStats(name)
+		rs.Lock()
+		rs.Merge(ors)
+		rs.Unlock()
+	}
+}
+
+// Returns the stats of a task, must be called with the lock held
+func (ts *Statistics) taskStats(name string, tags []string) *TaskStats {
+	t, ok := ts.Tasks[name]
+	if !ok {
+		t = NewTaskStat(name)
+		t.Tags = tags
+		ts.Tasks[name] = t
+	}
+
+	return t
+}
+
+// Returns the stats of a tag, must be called with the lock held
+func (ts *Statistics) tagStats(tag string) *TaskStats {
+	t, ok := ts.Tags[tag]
+	if !ok {
+		t = NewTaskStat(tag)
+		ts.Tags[tag] = t
+	}
+
+	return t
+}
+
+// Returns the stats of a phase, must be called with the lock held
+func (ts *Statistics) phaseStats(name string) *PhaseStats {
+	p, ok := ts.Phases[name]
+	if !ok {
+		p = NewPhaseStats(name)
+		ts.Phases[name] = p
+	}
+
+	return p
 }
 
-func (ts *Statistics) CleanRPSMap() {
-	now := time.Now().Unix()
+// Returns the stats of a request name, must be called with the lock held
+func (ts *Statistics) requestStats(name string) *RequestStats {
+	if _, ok := ts.Requests[name]; !ok && len(ts.Requests) >= MaxRequestNames {
+		name = otherErrorMessage
+	}
+
+	rs, ok := ts.Requests[name]
+	if !ok {
+		rs = NewRequestStats(name)
+		ts.Requests[name] = rs
+	}
+
+	return rs
+}
+
+// Records a task run in the current history interval
+func (ts *Statistics) recordInterval(name string, tr *TaskRun) {
+	ts.interval.Record(tr)
+
+	is, ok := ts.taskIntervals[name]
+	if !ok {
+		is = newIntervalStats()
+		ts.taskIntervals[name] = is
+	}
+	is.Record(tr)
+}
+
+// Adds a snapshot of the current interval to the history and starts a new interval
+func (ts *Statistics) TakeSnapshot() {
+	now := time.Now()
+	secs := now.Sub(ts.lastSnapshot).Seconds()
+	ts.lastSnapshot = now
+	if secs <= 0 {
+		return
+	}
+
+	snap := &StatsSnapshot{
+		Time:              now,
+		RPS:               float64(ts.interval.NumTotal) / secs,
+		FailuresPerSecond: float64(ts.interval.NumFailed) / secs,
+		RunningUsers:      ts.RunningUsers,
+		P50:               float64(ts.interval.Histogram.ValueAtPercentile(50)) / 1000,
+		P95:               float64(ts.interval.Histogram.ValueAtPercentile(95)) / 1000,
+		P99:               float64(ts.interval.Histogram.ValueAtPercentile(99)) / 1000,
+		Tasks:             make(map[string]*TaskSnapshot, len(ts.taskIntervals)),
+	}
 
-	var count int64
-	for uts := range ts.RPSMap {
-		if uts < now-RPSTimeWindow {
-			delete(ts.RPSMap, uts)
+	for name, is := range ts.taskIntervals {
+		snap.Tasks[name] = &TaskSnapshot{
+			RPS:               float64(is.NumTotal) / secs,
+			FailuresPerSecond: float64(is.NumFailed) / secs,
+			P50:               float64(is.Histogram.ValueAtPercentile(50)) / 1000,
+			P95:               float64(is.Histogram.ValueAtPercentile(95)) / 1000,
+			P99:               float64(is.Histogram.ValueAtPercentile(99)) / 1000,
 		}
+		is.Reset()
 	}
 
-	ts.CurrentRPS = float32(count) / float32(RPSTimeWindow)
+	ts.interval.Reset()
+	ts.History.Add(snap)
+}
+
+// Copies the throughput counters of other, which aren't part of the JSON of the
+// stats, to the stats with the same names, e.g. of a report copied from other
+func (ts *Statistics) copyThroughput(other *Statistics) {
+	ts.Throughput = other.Throughput.Copy()
+	copyTaskThroughput(ts.Tasks, other.Tasks)
+	copyTaskThroughput(ts.Tags, other.Tags)
+	for name, p := range ts.Phases {
+		if op, ok := other.Phases[name]; ok {
+			copyTaskThroughput(p.Tasks, op.Tasks)
+		}
+	}
+	for name, r := range ts.Requests {
+		if or, ok := other.Requests[name]; ok {
+			or.Lock()
+			r.Throughput = or.Throughput.Copy()
+			or.Unlock()
+		}
+	}
+}
+
+func copyTaskThroughput(tasks map[string]*TaskStats, other map[string]*TaskStats) {
+	for name, t := range tasks {
+		if ot, ok := other[name]; ok {
+			ot.Lock()
+			t.Throughput = ot.Throughput.Copy()
+			ot.Unlock()
+		} else {
+			t.Throughput = NewRateCounter()
+		}
+	}
+}
+
+// Updates the current throughput, and the peak RPS if steady is true
+func (ts *Statistics) UpdateThroughput(now time.Time, steady bool) {
+	ts.CurrentRPS, ts.CurrentFailuresPerSecond = ts.Throughput.Rates(now, ts.RPSWindow)
+	if steady && ts.CurrentRPS > ts.PeakRPS {
+		ts.PeakRPS = ts.CurrentRPS
+	}
 }
 
 func (ts *Statistics) Calculate() {
 	const MinRunsToCalculate = 10
+
+	now := time.Now()
+	ts.CurrentRPS, ts.CurrentFailuresPerSecond = ts.Throughput.Rates(now, ts.RPSWindow)
+	ts.CurrentBytesSentPerSecond, ts.CurrentBytesReceivedPerSecond = ts.Throughput.ByteRates(now, ts.RPSWindow)
+	ts.Connections.Calculate()
+	for _, t := range ts.Tasks {
+		t.CurrentRPS, t.CurrentFailuresPerSecond = t.Throughput.Rates(now, ts.RPSWindow)
+		t.CurrentBytesSentPerSecond, t.CurrentBytesReceivedPerSecond = t.Throughput.ByteRates(now, ts.RPSWindow)
+	}
+	for _, t := range ts.Tags {
+		t.CurrentRPS, t.CurrentFailuresPerSecond = t.Throughput.Rates(now, ts.RPSWindow)
+		t.CurrentBytesSentPerSecond, t.CurrentBytesReceivedPerSecond = t.Throughput.ByteRates(now, ts.RPSWindow)
+	}
+	for _, p := range ts.Phases {
+		for _, t := range p.Tasks {
+			t.CurrentRPS, t.CurrentFailuresPerSecond = t.Throughput.Rates(now, ts.RPSWindow)
+			t.CurrentBytesSentPerSecond, t.CurrentBytesReceivedPerSecond = t.Throughput.ByteRates(now, ts.RPSWindow)
+		}
+	}
+	for _, r := range ts.Requests {
+		r.Lock()
+		r.CurrentBytesSentPerSecond, r.CurrentBytesReceivedPerSecond = r.Throughput.ByteRates(now, ts.RPSWindow)
+		r.Connections.Calculate()
+		r.Unlock()
+	}
+
 	if ts.NumTotal < MinRunsToCalculate {
 		return
 	}
 
-	now := time.Now().Unix()
-
-	var count int64
-	for uts, c := range ts.RPSMap {
-		if uts >= now-RPSTimeWindow {
-			count += c
-		}
+	// The steady phase is from start to end
+	end := now
+	if ts.EndTime.After(ts.StartTime) {
+		end = ts.EndTime
+	}
+	steady, ok := ts.Phases[PhaseSteady]
+	if secs := end.Sub(ts.StartTime).Seconds(); ok && !ts.StartTime.IsZero() && secs > 0 {
+		ts.MeanRPS = float32(float64(steady.NumTotal) / secs)
 	}
 
-	ts.CurrentRPS = float32(count) / float32(RPSTimeWindow)
 	ts.AverageDuration = float32(ts.TotalDuration) / float32(ts.NumTotal)
 
 	for _, t := range ts.Tasks {
 		t.Calculate()
 	}
+
+	for _, t := range ts.Tags {
+		t.Calculate()
+	}
+
+	for _, p := range ts.Phases {
+		p.Calculate()
+	}
 }
 
 func NewStatistics() *Statistics {
 	return &Statistics{
 		Tasks:           make(map[string]*TaskStats),
-		RPSMap:          make(map[int64]int64),
+		Tags:            make(map[string]*TaskStats),
+		Phases:          make(map[string]*PhaseStats),
+		Requests:        make(map[string]*RequestStats),
+		Throughput:      NewRateCounter(),
+		RPSWindow:       RPSTimeWindow,
 		CurrentRPS:      0,
 		AverageDuration: 0,
+		History:         NewStatsHistory(DefaultHistorySize),
+		interval:        newIntervalStats(),
+		taskIntervals:   make(map[string]*intervalStats),
+		lastSnapshot:    time.Now(),
 	}
 }
@@ -0,0 +1,310 @@
+package ltt
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatsDProtocolStatsD   = "statsd"
+	StatsDProtocolGraphite = "graphite"
+)
+
+// Max size of a StatsD UDP packet, to stay below common MTUs
+const statsDMaxPacketSize = 1432
+
+// Max durations of a task sent as StatsD timers per flush, a random sample is
+// sent with its sample rate if there were more runs
+const StatsDMaxTimings = 1000
+
+var metricNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Turns a task full name into a dot separated metric path, e.g. "auth.profile.view"
+func metricPath(name string) string {
+	parts := strings.Split(name, " / ")
+	for i, p := range parts {
+		parts[i] = strings.Trim(metricNameRegexp.ReplaceAllString(p, "_"), "_")
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Results of a task since the last flush
+type statsDTaskStats struct {
+	numTotal  int64
+	numFailed int64
+	errors    map[ErrorClass]int64
+	histogram *Histogram
+	// Sampled durations in milliseconds, sent as StatsD timers
+	timings []float64
+}
+
+// Keeps a uniform sample of at most StatsDMaxTimings durations, numTotal
+// must already include the run
+func (ts *statsDTaskStats) addTiming(ms float64) {
+	if len(ts.timings) < StatsDMaxTimings {
+		ts.timings = append(ts.timings, ms)
+	} else if i := rand.Int63n(ts.numTotal); i < StatsDMaxTimings {
+		ts.timings[i] = ms
+	}
+}
+
+// Pushes aggregated task results and user counts on an interval to StatsD
+// over UDP, or to Graphite over TCP using the plaintext protocol
+type StatsDExporter struct {
+	sync.Mutex
+	lt       *LoadTest
+	Protocol string
+	Address  string
+	// Prefix of all metric paths, e.g. "ltt"
+	Prefix string
+	// Tags added to all metrics, in the DogStatsD format for StatsD and
+	// the tagged series format for Graphite
+	Tags     map[string]string
+	Interval time.Duration
+	conn     net.Conn
+	tasks    map[string]*statsDTaskStats
+	// Serializes flushes, which use the connection
+	flushLock sync.Mutex
+}
+
+func (e *StatsDExporter) HandleTaskRun(tr *TaskRun) {
+	name := tr.Task.FullName()
+
+	e.Lock()
+	defer e.Unlock()
+
+	ts, ok := e.tasks[name]
+	if !ok {
+		ts = &statsDTaskStats{errors: make(map[ErrorClass]int64), histogram: NewHistogram()}
+		e.tasks[name] = ts
+	}
+
+	ts.numTotal++
+	ts.histogram.RecordDuration(tr.Duration)
+	if e.Protocol != StatsDProtocolGraphite {
+		ts.addTiming(float64(tr.Duration.Microseconds()) / 1000)
+	}
+	if tr.Error != nil {
+		ts.numFailed++
+		class, _ := ClassifyError(tr.Error)
+		ts.errors[class]++
+	}
+}
+
+func (e *StatsDExporter) metricName(path string) string {
+	if e.Prefix != "" {
+		path = e.Prefix + "." + path
+	}
+
+	return path
+}
+
+// Formats a single metric line, typ is the StatsD metric type ("c", "g" or "ms")
+// and may include a sample rate, e.g. "ms|@0.5"
+func (e *StatsDExporter) line(path string, value string, typ string, now time.Time) string {
+	keys := make([]string, 0, len(e.Tags))
+	for k := range e.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	name := e.metricName(path)
+	if e.Protocol == StatsDProtocolGraphite {
+		for _, k := range keys {
+			name += fmt.Sprintf(";%s=%s", k, e.Tags[k])
+		}
+		return fmt.Sprintf("%s %s %d\n", name, value, now.Unix())
+	}
+
+	l := fmt.Sprintf("%s:%s|%s", name, value, typ)
+	if len(keys) > 0 {
+		tags := make([]string, len(keys))
+		for i, k := range keys {
+			tags[i] = k + ":" + e.Tags[k]
+		}
+		l += "|#" + strings.Join(tags, ",")
+	}
+
+	return l + "\n"
+}
+
+// Returns the metric lines of the results since the last flush and resets them
+func (e *StatsDExporter) collect(now time.Time) []string {
+	lines := []string{}
+
+	e.lt.Stats.Lock()
+	users := e.lt.Stats.RunningUsers
+	e.lt.Stats.Unlock()
+	lines = append(lines, e.line("users", fmt.Sprint(users), "g", now))
+
+	e.Lock()
+	defer e.Unlock()
+
+	names := make([]string, 0, len(e.tasks))
+	for name := range e.tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ts := e.tasks[name]
+		if ts.numTotal == 0 {
+			continue
+		}
+
+		path := "tasks." + metricPath(name)
+		h := ts.histogram
+		lines = append(lines,
+			e.line(path+".count", fmt.Sprint(ts.numTotal), "c", now),
+			e.line(path+".failures", fmt.Sprint(ts.numFailed), "c", now),
+		)
+
+		if e.Protocol == StatsDProtocolGraphite {
+			// Graphite has no timers, so the aggregates are sent
+			lines = append(lines,
+				e.line(path+".timing.mean", formatFloat(h.Mean()/1000), "g", now),
+				e.line(path+".timing.p50", formatFloat(float64(h.ValueAtPercentile(50))/1000), "g", now),
+				e.line(path+".timing.p95", formatFloat(float64(h.ValueAtPercentile(95))/1000), "g", now),
+				e.line(path+".timing.p99", formatFloat(float64(h.ValueAtPercentile(99))/1000), "g", now),
+				e.line(path+".timing.max", formatFloat(float64(h.Max())/1000), "g", now),
+			)
+		} else {
+			// The durations are sent as timers for StatsD to aggregate
+			typ := "ms"
+			if n := int64(len(ts.timings)); n < ts.numTotal {
+				typ += "|@" + strconv.FormatFloat(float64(n)/float64(ts.numTotal), 'g', 4, 64)
+			}
+			for _, ms := range ts.timings {
+				lines = append(lines, e.line(path+".timing", formatFloat(ms), typ, now))
+			}
+		}
+
+		for class, c := range ts.errors {
+			lines = append(lines, e.line(path+".errors."+string(class), fmt.Sprint(c), "c", now))
+		}
+
+		ts.numTotal = 0
+		ts.numFailed = 0
+		ts.errors = make(map[ErrorClass]int64)
+		ts.timings = ts.timings[:0]
+		h.Reset()
+	}
+
+	return lines
+}
+
+func (e *StatsDExporter) dial() error {
+	if e.conn != nil {
+		return nil
+	}
+
+	network := "udp"
+	if e.Protocol == StatsDProtocolGraphite {
+		network = "tcp"
+	}
+
+	conn, err := net.DialTimeout(network, e.Address, time.Second*5)
+	if err != nil {
+		return err
+	}
+
+	e.conn = conn
+	return nil
+}
+
+func (e *StatsDExporter) send(lines []string) error {
+	if err := e.dial(); err != nil {
+		return err
+	}
+
+	// Graphite is a stream, StatsD lines are batched into packets
+	maxSize := statsDMaxPacketSize
+	if e.Protocol == StatsDProtocolGraphite {
+		maxSize = 64 * 1024
+	}
+
+	buf := &bytes.Buffer{}
+	write := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		_, err := e.conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+
+	for _, l := range lines {
+		if buf.Len()+len(l) > maxSize {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(l)
+	}
+
+	return write()
+}
+
+// Pushes the results since the last flush
+func (e *StatsDExporter) Flush() error {
+	e.flushLock.Lock()
+	defer e.flushLock.Unlock()
+
+	lines := e.collect(time.Now())
+
+	if err := e.send(lines); err != nil {
+		// Reconnect on the next flush
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Flushes on the exporter interval, forever
+func (e *StatsDExporter) Run() {
+	for {
+		time.Sleep(e.Interval)
+		if err := e.Flush(); err != nil {
+			e.lt.Log.Printf("StatsDExporter: failed to push metrics to %s: %s\n", e.Address, err.Error())
+		}
+	}
+}
+
+func NewStatsDExporter(lt *LoadTest, protocol string, address string, prefix string, interval time.Duration) *StatsDExporter {
+	return &StatsDExporter{
+		lt:       lt,
+		Protocol: protocol,
+		Address:  address,
+		Prefix:   prefix,
+		Tags:     make(map[string]string),
+		Interval: interval,
+		tasks:    make(map[string]*statsDTaskStats),
+	}
+}
+
+// Parses "k=v,k2=v2" into a map
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			tags[parts[0]] = parts[1]
+		}
+	}
+
+	return tags
+}
@@ -0,0 +1,96 @@
+package ltt
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reads the lines of the packets sent to conn until none arrives for a while
+func readStatsDLines(t *testing.T, conn net.PacketConn) []string {
+	lines := []string{}
+	buf := make([]byte, 64*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return lines
+			}
+			t.Fatal(err)
+		}
+		if n > statsDMaxPacketSize {
+			t.Fatalf("packet of %d bytes is larger than %d", n, statsDMaxPacketSize)
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+}
+
+func TestStatsDExporterSendsTimers(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lt := NewLoadTest(Config{})
+	e := NewStatsDExporter(lt, StatsDProtocolStatsD, conn.LocalAddr().String(), "ltt", time.Second)
+	e.Tags["env"] = "test"
+	task := NewTask("view", nil, nil, TaskOptions{})
+	for _, d := range []time.Duration{10, 20, 30} {
+		e.HandleTaskRun(&TaskRun{Task: task, Duration: d * time.Millisecond})
+	}
+
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	timers := 0
+	for _, l := range readStatsDLines(t, conn) {
+		if strings.Contains(l, "|g") && strings.Contains(l, ".timing") {
+			t.Fatalf("timing sent as a gauge: %q", l)
+		}
+		if strings.HasPrefix(l, "ltt.tasks.view.timing:") {
+			if !strings.HasSuffix(l, "|ms|#env:test") {
+				t.Fatalf("timing line %q is not a tagged timer", l)
+			}
+			timers++
+		}
+	}
+	if timers != 3 {
+		t.Fatalf("got %d timers, want 3", timers)
+	}
+}
+
+func TestStatsDExporterSamplesTimers(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lt := NewLoadTest(Config{})
+	e := NewStatsDExporter(lt, StatsDProtocolStatsD, conn.LocalAddr().String(), "", time.Second)
+	task := NewTask("view", nil, nil, TaskOptions{})
+	for i := 0; i < 4*StatsDMaxTimings; i++ {
+		e.HandleTaskRun(&TaskRun{Task: task, Duration: time.Millisecond})
+	}
+
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	timers := 0
+	for _, l := range readStatsDLines(t, conn) {
+		if strings.HasPrefix(l, "tasks.view.timing:") {
+			if l != "tasks.view.timing:1.00|ms|@0.25" {
+				t.Fatalf("unexpected timing line %q", l)
+			}
+			timers++
+		}
+	}
+	if timers != StatsDMaxTimings {
+		t.Fatalf("got %d timers, want %d", timers, StatsDMaxTimings)
+	}
+}
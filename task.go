@@ -2,7 +2,9 @@ package ltt
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"time"
 )
 
 type TaskFunc func(context.Context) error
@@ -17,6 +19,29 @@ type TaskOptions struct {
 	SelectionStrategy TaskSelectionStrategyType
 	StepOutWeight     int
 	Weight            int
+	// If set, the task is only selected when the condition returns true,
+	// e.g. only checkout when the cart in the user's Storage is non-empty
+	Condition func(context.Context) bool
+	// Tags to aggregate the task's stats by, e.g. "read", "write" or "critical".
+	// Subtasks inherit the tags of their parents.
+	Tags []string
+	// Apdex threshold T, runs within T are satisfied and within 4T tolerating.
+	// Subtasks inherit the threshold of their parents, Config.ApdexThreshold is the default.
+	ApdexThreshold time.Duration
+	// Expected interval between the starts of a user's runs of the task, to correct
+	// the latencies for coordinated omission. Subtasks inherit the interval of their
+	// parents, Config.ExpectedInterval is the default.
+	ExpectedInterval time.Duration
+}
+
+// TaskPanicError is the error recorded for a task run whose function panicked
+type TaskPanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *TaskPanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
 }
 
 type Task struct {
@@ -42,6 +67,63 @@ func (t *Task) AddSubTask(name string, f TaskFunc, opts TaskOptions) *Task {
 	return st
 }
 
+// Reports if the task may be selected to run in the given user context
+func (t *Task) Eligible(ctx context.Context) bool {
+	if t.Options.Condition == nil {
+		return true
+	}
+
+	return t.Options.Condition(ctx)
+}
+
+// Returns the entry task of the tree this task belongs to
+func (t *Task) Root() *Task {
+	r := t
+	for r.Parent != nil {
+		r = r.Parent
+	}
+
+	return r
+}
+
+// Returns the task's tags and the tags of its parents, without duplicates
+func (t *Task) Tags() []string {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for p := t; p != nil; p = p.Parent {
+		for _, tag := range p.Options.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// Returns the Apdex threshold of the task or its closest parent with one, 0 if none has
+func (t *Task) ApdexThreshold() time.Duration {
+	for p := t; p != nil; p = p.Parent {
+		if p.Options.ApdexThreshold > 0 {
+			return p.Options.ApdexThreshold
+		}
+	}
+
+	return 0
+}
+
+// Returns the expected interval of the task or its closest parent with one, 0 if none has
+func (t *Task) ExpectedInterval() time.Duration {
+	for p := t; p != nil; p = p.Parent {
+		if p.Options.ExpectedInterval > 0 {
+			return p.Options.ExpectedInterval
+		}
+	}
+
+	return 0
+}
+
 func (t *Task) FullName() string {
 	if t.Parent == nil {
 		return t.Name
@@ -0,0 +1,127 @@
+package ltt
+
+import (
+	"time"
+)
+
+// Max sliding window in seconds a RateCounter can calculate rates over
+const MaxRPSWindow = 300
+
+type rateBucket struct {
+	unix          int64
+	numTotal      int64
+	numFailed     int64
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// Counts runs per second in a ring of one second buckets to calculate
+// sliding window rates. Not safe for concurrent use.
+type RateCounter struct {
+	buckets [MaxRPSWindow + 1]rateBucket
+}
+
+func (rc *RateCounter) bucket(unix int64) *rateBucket {
+	b := &rc.buckets[unix%int64(len(rc.buckets))]
+	if b.unix != unix {
+		*b = rateBucket{unix: unix}
+	}
+
+	return b
+}
+
+// Counts a run that finished at t
+func (rc *RateCounter) Record(t time.Time, failed bool) {
+	b := rc.bucket(t.Unix())
+	b.numTotal++
+	if failed {
+		b.numFailed++
+	}
+}
+
+// Counts bytes sent and received at t
+func (rc *RateCounter) RecordBytes(t time.Time, sent int64, received int64) {
+	b := rc.bucket(t.Unix())
+	b.bytesSent += sent
+	b.bytesReceived += received
+}
+
+// Returns the bytes sent and received per second over the last window seconds before now,
+// the current, incomplete second is not included. A nil counter, e.g. of stats
+// loaded from JSON, has no rates.
+func (rc *RateCounter) ByteRates(now time.Time, window int) (float64, float64) {
+	if rc == nil || window <= 0 {
+		return 0, 0
+	} else if window > MaxRPSWindow {
+		window = MaxRPSWindow
+	}
+
+	unix := now.Unix()
+	var sent, received int64
+	for _, b := range rc.buckets {
+		if b.unix >= unix-int64(window) && b.unix < unix {
+			sent += b.bytesSent
+			received += b.bytesReceived
+		}
+	}
+
+	return float64(sent) / float64(window), float64(received) / float64(window)
+}
+
+// Returns the runs and failures per second over the last window seconds before now,
+// the current, incomplete second is not included. A nil counter has no rates.
+func (rc *RateCounter) Rates(now time.Time, window int) (float32, float32) {
+	if rc == nil || window <= 0 {
+		return 0, 0
+	} else if window > MaxRPSWindow {
+		window = MaxRPSWindow
+	}
+
+	unix := now.Unix()
+	var total, failed int64
+	for _, b := range rc.buckets {
+		if b.unix >= unix-int64(window) && b.unix < unix {
+			total += b.numTotal
+			failed += b.numFailed
+		}
+	}
+
+	return float32(total) / float32(window), float32(failed) / float32(window)
+}
+
+// Adds the counts of another counter, buckets older than the ones they would replace are dropped
+func (rc *RateCounter) Merge(other *RateCounter) {
+	for _, ob := range other.buckets {
+		if ob.unix == 0 {
+			continue
+		}
+
+		if rc.buckets[ob.unix%int64(len(rc.buckets))].unix > ob.unix {
+			continue
+		}
+
+		b := rc.bucket(ob.unix)
+		b.numTotal += ob.numTotal
+		b.numFailed += ob.numFailed
+		b.bytesSent += ob.bytesSent
+		b.bytesReceived += ob.bytesReceived
+	}
+}
+
+// Returns a copy of the counter, an empty counter if it's nil
+func (rc *RateCounter) Copy() *RateCounter {
+	if rc == nil {
+		return NewRateCounter()
+	}
+
+	c := *rc
+	return &c
+}
+
+func (rc *RateCounter) Reset() {
+	*rc = RateCounter{}
+}
+
+func NewRateCounter() *RateCounter {
+	return &RateCounter{}
+}
@@ -0,0 +1,206 @@
+package ltt
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Where the time of a request went, phases that didn't happen are zero,
+// e.g. DNS, Connect and TLS on a reused connection
+type RequestTiming struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// From the request being written to the first response byte
+	TTFB time.Duration
+	// From the first response byte to the body being read
+	Transfer time.Duration
+	// If true, the request was sent on a reused connection
+	Reused bool
+	// If false, the request failed before it got a connection
+	GotConn bool
+}
+
+// Collects the timestamps of a request with httptrace. The hooks are locked
+// since dials can finish after the request got another connection.
+type requestTimer struct {
+	sync.Mutex
+	dnsStart, connectStart, tlsStart, wroteRequest, firstByte time.Time
+	timing                                                    RequestTiming
+}
+
+func (rt *requestTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.timing.GotConn = true
+			rt.timing.Reused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rt.Lock()
+			defer rt.Unlock()
+			if !rt.dnsStart.IsZero() {
+				rt.timing.DNS = time.Now().Sub(rt.dnsStart)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			rt.Lock()
+			defer rt.Unlock()
+			if !rt.connectStart.IsZero() {
+				rt.timing.Connect = time.Now().Sub(rt.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rt.Lock()
+			defer rt.Unlock()
+			if !rt.tlsStart.IsZero() {
+				rt.timing.TLS = time.Now().Sub(rt.tlsStart)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			rt.Lock()
+			defer rt.Unlock()
+			rt.firstByte = time.Now()
+			if !rt.wroteRequest.IsZero() {
+				rt.timing.TTFB = rt.firstByte.Sub(rt.wroteRequest)
+			}
+		},
+	}
+}
+
+// Returns the timing, must be called when the response body has been read
+func (rt *requestTimer) finish() RequestTiming {
+	rt.Lock()
+	defer rt.Unlock()
+	if !rt.firstByte.IsZero() {
+		rt.timing.Transfer = time.Now().Sub(rt.firstByte)
+	}
+
+	return rt.timing
+}
+
+// Returns a request that records its timing
+func traceRequest(req *http.Request) (*http.Request, *requestTimer) {
+	rt := &requestTimer{}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), rt.trace())), rt
+}
+
+// Count, mean and max of a request phase in milliseconds
+type DurationStats struct {
+	Count   int64   `json:"count"`
+	Total   float64 `json:"total"`
+	Average float64 `json:"average"`
+	Max     float64 `json:"max"`
+}
+
+func (ds *DurationStats) Record(d time.Duration) {
+	ms := float64(d.Microseconds()) / 1000
+	ds.Count++
+	ds.Total += ms
+	if ms > ds.Max {
+		ds.Max = ms
+	}
+}
+
+func (ds *DurationStats) Merge(other *DurationStats) {
+	ds.Count += other.Count
+	ds.Total += other.Total
+	if other.Max > ds.Max {
+		ds.Max = other.Max
+	}
+}
+
+func (ds *DurationStats) Calculate() {
+	if ds.Count > 0 {
+		ds.Average = ds.Total / float64(ds.Count)
+	}
+}
+
+// Aggregated request timings and connection reuse. DNS, Connect and TLS only
+// count the requests that made a new connection.
+type ConnectionStats struct {
+	DNS      DurationStats `json:"dns"`
+	Connect  DurationStats `json:"connect"`
+	TLS      DurationStats `json:"tls"`
+	TTFB     DurationStats `json:"ttfb"`
+	Transfer DurationStats `json:"transfer"`
+	// Requests sent on a new and on a reused connection
+	NewConnections    int64 `json:"new_connections"`
+	ReusedConnections int64 `json:"reused_connections"`
+	// Reused connections relative to all requests that got a connection
+	ReuseRatio float64 `json:"reuse_ratio"`
+}
+
+func (cs *ConnectionStats) Record(t *RequestTiming) {
+	if !t.GotConn {
+		return
+	}
+
+	if t.Reused {
+		cs.ReusedConnections++
+	} else {
+		cs.NewConnections++
+	}
+
+	if t.DNS > 0 {
+		cs.DNS.Record(t.DNS)
+	}
+	if t.Connect > 0 {
+		cs.Connect.Record(t.Connect)
+	}
+	if t.TLS > 0 {
+		cs.TLS.Record(t.TLS)
+	}
+	if t.TTFB > 0 {
+		cs.TTFB.Record(t.TTFB)
+	}
+	if t.Transfer > 0 {
+		cs.Transfer.Record(t.Transfer)
+	}
+}
+
+func (cs *ConnectionStats) Merge(other *ConnectionStats) {
+	cs.DNS.Merge(&other.DNS)
+	cs.Connect.Merge(&other.Connect)
+	cs.TLS.Merge(&other.TLS)
+	cs.TTFB.Merge(&other.TTFB)
+	cs.Transfer.Merge(&other.Transfer)
+	cs.NewConnections += other.NewConnections
+	cs.ReusedConnections += other.ReusedConnections
+}
+
+func (cs *ConnectionStats) Calculate() {
+	cs.DNS.Calculate()
+	cs.Connect.Calculate()
+	cs.TLS.Calculate()
+	cs.TTFB.Calculate()
+	cs.Transfer.Calculate()
+
+	if total := cs.NewConnections + cs.ReusedConnections; total > 0 {
+		cs.ReuseRatio = float64(cs.ReusedConnections) / float64(total)
+	}
+}
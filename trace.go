@@ -0,0 +1,165 @@
+package ltt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type traceContextKeyType int
+
+var traceContextKey traceContextKeyType
+
+// Number of slowest and latest failed samples with trace IDs kept per task
+const MaxTraceSamples = 5
+
+// The trace of the task run a user is currently running, see Config.TraceContext.
+// Tasks can make requests from several goroutines, so it's locked.
+type traceState struct {
+	sync.Mutex
+	traceID string
+	// If true, a task run is running and a trace ID is created for it on its first
+	// request, for HTTPClients with PropagateTraceContext set without Config.TraceContext
+	running bool
+}
+
+// Starts a task run, with a trace ID if enabled is true
+func (ts *traceState) start(enabled bool) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	ts.running = true
+	ts.traceID = ""
+	if enabled {
+		ts.traceID = newTraceID()
+	}
+}
+
+// Returns the trace ID of the current run, creating one if it has none
+func (ts *traceState) ensure() string {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.traceID == "" && ts.running {
+		ts.traceID = newTraceID()
+	}
+
+	return ts.traceID
+}
+
+// Ends the current run and returns its trace ID, empty if it had none
+func (ts *traceState) finish() string {
+	ts.Lock()
+	defer ts.Unlock()
+
+	traceID := ts.traceID
+	ts.running = false
+	ts.traceID = ""
+
+	return traceID
+}
+
+func newTraceStateContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceContextKey, &traceState{})
+}
+
+func traceStateFromContext(ctx context.Context) *traceState {
+	if ts, ok := ctx.Value(traceContextKey).(*traceState); ok {
+		return ts
+	}
+
+	return nil
+}
+
+// Returns the trace ID of the user's current task run, empty if tracing is disabled
+// or the run hasn't made a request with a client that propagates trace context
+func TraceIDFromContext(ctx context.Context) string {
+	if ts := traceStateFromContext(ctx); ts != nil {
+		ts.Lock()
+		defer ts.Unlock()
+		return ts.traceID
+	}
+
+	return ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %s", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func newTraceID() string {
+	return randomHex(16)
+}
+
+func newSpanID() string {
+	return randomHex(8)
+}
+
+// Sets the W3C traceparent header with a new span ID for the trace
+func setTraceParent(h http.Header, traceID string) {
+	h.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, newSpanID()))
+}
+
+// A task run with a trace ID, to find slow or failed runs in a tracing backend
+type TraceSample struct {
+	TraceID string    `json:"trace_id"`
+	Time    time.Time `json:"time"`
+	// Duration in milliseconds
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func newTraceSample(tr *TaskRun) *TraceSample {
+	s := &TraceSample{
+		TraceID:  tr.TraceID,
+		Time:     tr.StartTime,
+		Duration: float64(tr.Duration.Microseconds()) / 1000,
+	}
+
+	if tr.Error != nil {
+		s.Error = truncate(tr.Error.Error(), MaxErrorSampleSize)
+	}
+
+	return s
+}
+
+// Keeps the slowest samples sorted, slowest first
+func addSlowestSample(samples []*TraceSample, s *TraceSample) []*TraceSample {
+	if len(samples) >= MaxTraceSamples && s.Duration <= samples[len(samples)-1].Duration {
+		return samples
+	}
+
+	i := len(samples)
+	for i > 0 && samples[i-1].Duration < s.Duration {
+		i--
+	}
+
+	samples = append(samples, nil)
+	copy(samples[i+1:], samples[i:])
+	samples[i] = s
+
+	if len(samples) > MaxTraceSamples {
+		samples = samples[:MaxTraceSamples]
+	}
+
+	return samples
+}
+
+// Keeps the latest samples, latest last
+func addLatestSample(samples []*TraceSample, s *TraceSample) []*TraceSample {
+	samples = append(samples, s)
+	if len(samples) > MaxTraceSamples {
+		samples = samples[len(samples)-MaxTraceSamples:]
+	}
+
+	return samples
+}
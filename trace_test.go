@@ -0,0 +1,45 @@
+package ltt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientPropagateTraceContextWithoutConfig(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	lt := NewLoadTest(Config{RequestTimeout: 5, StatsShards: 1})
+	lt.Status = StatusRunning
+	task := NewEntryTask("traced", func(ctx context.Context) error {
+		client := NewHTTPClient(ctx, srv.URL)
+		client.PropagateTraceContext = true
+		_, err := client.Get("/")
+		return err
+	}, TaskOptions{})
+
+	du := NewDefaultUser(task)
+	ctx := NewUserContext(NewLoadTestContext(context.Background(), lt), du)
+	du.SetContext(newRequestLogContext(newTraceStateContext(ctx)))
+	du.runTask()
+
+	tr := <-lt.statsShards[0].runs
+	if tr.Error != nil {
+		t.Fatal(tr.Error)
+	}
+	if tr.TraceID == "" {
+		t.Fatal("the run has no trace ID")
+	}
+	if !strings.HasPrefix(traceparent, "00-"+tr.TraceID+"-") {
+		t.Fatalf("traceparent %q is not of trace %s", traceparent, tr.TraceID)
+	}
+	if id := TraceIDFromContext(du.Context()); id != "" {
+		t.Fatalf("trace ID %s left after the run", id)
+	}
+}
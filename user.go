@@ -3,6 +3,7 @@ package ltt
 import (
 	"context"
 	"math/rand"
+	"runtime/debug"
 	"time"
 )
 
@@ -52,6 +53,8 @@ type DefaultUser struct {
 	task         *Task
 	subtaskIndex int
 	cancel       context.CancelFunc
+	// Set when a task panicked and the user should restart from the entry task
+	restart bool
 }
 
 func NewDefaultUser(task *Task) *DefaultUser {
@@ -108,22 +111,54 @@ func (du *DefaultUser) Spawn() {
 }
 
 func (du *DefaultUser) Tick() {
+	if du.restart {
+		du.restart = false
+		du.task = du.task.Root()
+		du.subtaskIndex = -1
+		// The context is kept, so that what the entry task set in it, e.g. an
+		// HTTPClient with its cookies, is still there when it runs again
+		du.runTask()
+		return
+	}
+
+	du.tick(0)
+}
+
+// Steps out to the parent task and selects the next task from there
+func (du *DefaultUser) stepOut(depth int) {
+	du.task = du.task.Parent
+	du.tick(depth + 1)
+}
+
+func (du *DefaultUser) tick(depth int) {
 	const poolStepOut = -1
+	// Max number of step outs in a single tick, reached when no task in the tree
+	// is eligible to run, the user will then try again on the next tick
+	const maxTickDepth = 100
+
+	if depth > maxTickDepth {
+		return
+	}
+
+	ctx := du.Context()
 
 	var next *Task
 	if du.task.Options.SelectionStrategy == TaskSelectionStrategyRandom {
 		// TOOD(jhamren): infinite loop check or validate loop-tree on startup
 		if du.task.Parent != nil && len(du.task.SubTasks) == 0 {
-			du.task = du.task.Parent
-			du.Tick()
+			du.stepOut(depth)
 			return
 		}
 
-		// Create a pool of the subtasks and their wight and pick a random index
+		// Create a pool of the eligible subtasks and their wight and pick a random index
 		// from the pool after shuffling it
-		pool := make([]int, len(du.task.SubTasks))
+		pool := make([]int, 0, len(du.task.SubTasks))
 
 		for i, t := range du.task.SubTasks {
+			if !t.Eligible(ctx) {
+				continue
+			}
+
 			pool = append(pool, i)
 
 			// Add the same index again to the pool according to its weight
@@ -132,6 +167,14 @@ func (du *DefaultUser) Tick() {
 			}
 		}
 
+		// Step out if there is nothing to run at this level
+		if len(pool) == 0 {
+			if du.task.Parent != nil {
+				du.stepOut(depth)
+			}
+			return
+		}
+
 		// Make sure that the task sometimes steps out of their subtasks
 		if du.task.Parent != nil {
 			pool = append(pool, poolStepOut)
@@ -147,28 +190,44 @@ func (du *DefaultUser) Tick() {
 
 		ix := pool[rand.Intn(len(pool))]
 		if ix == poolStepOut {
-			du.task = du.task.Parent
-			du.Tick()
+			du.stepOut(depth)
 			return
 		} else {
 			next = du.task.SubTasks[ix]
 		}
 	} else if du.task.Options.SelectionStrategy == TaskSelectionStrategyInOrder {
-		du.subtaskIndex++
-
-		if du.subtaskIndex >= len(du.task.SubTasks) {
-			du.subtaskIndex = 0
+		// Skip past the subtasks that are not eligible to run
+		for du.subtaskIndex+1 < len(du.task.SubTasks) {
+			du.subtaskIndex++
+			if du.task.SubTasks[du.subtaskIndex].Eligible(ctx) {
+				next = du.task.SubTasks[du.subtaskIndex]
+				break
+			}
+		}
 
+		if next == nil {
 			// all tasks have been run once, step out to parent task if there's one
-			// otherwise, start over on 0
+			// otherwise, start over on the first eligible task
 			if du.task.Parent != nil {
-				du.task = du.task.Parent
-				du.Tick()
+				du.subtaskIndex = 0
+				du.stepOut(depth)
 				return
 			}
-		}
 
-		next = du.task.SubTasks[du.subtaskIndex]
+			// Stays before the first task until one has been chosen
+			du.subtaskIndex = -1
+			for i, t := range du.task.SubTasks {
+				if t.Eligible(ctx) {
+					du.subtaskIndex = i
+					next = t
+					break
+				}
+			}
+
+			if next == nil {
+				return
+			}
+		}
 	} else {
 		FromContext(du.Context()).Log.Fatal("failed to select a task")
 	}
@@ -179,19 +238,56 @@ func (du *DefaultUser) Tick() {
 
 func (du *DefaultUser) runTask() {
 	if du.task.RunFunc != nil {
+		lt := FromContext(du.Context())
+
+		ts := traceStateFromContext(du.Context())
+		if ts != nil {
+			ts.start(lt.Config.TraceContext)
+		}
+
+		StartRequests(du.Context())
 		start := time.Now()
-		err := du.task.RunFunc(du.Context())
+		err := du.callTask()
 
 		duration := time.Now().Sub(start)
-		lt := FromContext(du.Context())
-		lt.TaskRunChan <- &TaskRun{
-			Task:     du.task,
-			Duration: duration,
-			Error:    err,
+		requests := TakeRequests(du.Context())
+		var traceID string
+		if ts != nil {
+			traceID = ts.finish()
+		}
+
+		lt.RecordTaskRun(&TaskRun{
+			Task:             du.task,
+			StartTime:        start,
+			Duration:         duration,
+			Error:            err,
+			UserID:           du.ID(),
+			UserClass:        lt.UserClass(),
+			Tags:             append(du.task.Tags(), lt.UserLabels()...),
+			ApdexThreshold:   lt.ApdexThreshold(du.task),
+			ExpectedInterval: lt.ExpectedInterval(du.task),
+			TraceID:          traceID,
+			Requests:         requests,
+		})
+
+		if pe, ok := err.(*TaskPanicError); ok {
+			lt.Log.Printf("DefaultUser(%d): task %s panicked: %v\n", du.ID(), du.task.FullName(), pe.Value)
+			du.restart = lt.Config.RestartOnPanic
 		}
 	}
 }
 
+// Runs the current task's function and turns a panic into a TaskPanicError
+func (du *DefaultUser) callTask() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &TaskPanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return du.task.RunFunc(du.Context())
+}
+
 func (du *DefaultUser) SleepSeconds(seconds int) {
 	ctx, cancel := context.WithTimeout(du.Context(), time.Second*time.Duration(seconds))
 	du.cancel = cancel
@@ -0,0 +1,55 @@
+package ltt
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+type testLoginKeyType int
+
+var testLoginKey testLoginKeyType
+
+func TestRestartOnPanicKeepsContext(t *testing.T) {
+	lt := NewLoadTest(Config{RestartOnPanic: true, StatsShards: 1, LogOutput: ioutil.Discard})
+	logins := 0
+	entry := NewEntryTask("entry", func(ctx context.Context) error {
+		// Logs in once and keeps the login in the user context
+		if ctx.Value(testLoginKey) == nil {
+			logins++
+			u := UserFromContext(ctx)
+			u.SetContext(context.WithValue(ctx, testLoginKey, "session"))
+		}
+		return nil
+	}, TaskOptions{})
+	panicked := false
+	entry.AddSubTask("view", func(ctx context.Context) error {
+		if !panicked {
+			panicked = true
+			panic("view failed")
+		}
+		return nil
+	}, TaskOptions{})
+
+	du := NewDefaultUser(entry)
+	ctx := NewUserContext(NewLoadTestContext(context.Background(), lt), du)
+	du.SetContext(NewStorageContext(ctx, NewStorage()))
+	du.Spawn()
+	// Panics in view
+	du.Tick()
+	if !du.restart {
+		t.Fatal("the user isn't restarted after the panic")
+	}
+	// Runs the entry task again
+	du.Tick()
+
+	if du.task != entry {
+		t.Fatalf("the user is at %s, want the entry task", du.task.FullName())
+	}
+	if logins != 1 {
+		t.Fatalf("logged in %d times, want the login kept on restart", logins)
+	}
+	if du.Context().Value(testLoginKey) == nil {
+		t.Fatal("the context of the entry task was dropped on restart")
+	}
+}